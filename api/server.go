@@ -0,0 +1,319 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/sirupsen/logrus"
+
+	"go-contract-indexer/db"
+)
+
+// transferTypes are the event types of the token movements listed for an address
+var transferTypes = []string{"Transfer", "Mint", "Burn"}
+
+// Server serves the indexed events and balances of a database as JSON over HTTP:
+//
+//	GET /events                          events matching the query parameters
+//	GET /events/{txHash}                 events of a transaction
+//	GET /addresses/{address}/transfers   transfers, mints and burns of an address
+//	GET /addresses/{address}/balance     balances of an address
+//
+// Event lists are filtered by the contract, address, tx_hash, type, from_block and
+// to_block parameters and paged by limit and cursor. Amounts are decimal strings.
+type Server struct {
+	db        db.Interface
+	contracts []string
+	logger    logrus.FieldLogger
+	mux       *http.ServeMux
+}
+
+// Event is the JSON representation of an indexed event
+type Event struct {
+	Contract    string  `json:"contract"`
+	BlockNumber uint64  `json:"block_number"`
+	BlockHash   string  `json:"block_hash"`
+	TxHash      string  `json:"tx_hash"`
+	LogIndex    uint    `json:"log_index"`
+	Type        string  `json:"type"`
+	From        *string `json:"from,omitempty"`
+	To          *string `json:"to,omitempty"`
+	Owner       *string `json:"owner,omitempty"`
+	Spender     *string `json:"spender,omitempty"`
+	Value       *string `json:"value,omitempty"`
+}
+
+// EventPage is the JSON representation of a page of events. NextCursor is passed as the
+// cursor parameter to fetch the next page, and is omitted on the last page.
+type EventPage struct {
+	Events     []Event `json:"events"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// Balance is the JSON representation of the balance of a token
+type Balance struct {
+	Contract string `json:"contract"`
+	Balance  string `json:"balance"`
+}
+
+// Balances is the JSON representation of the balances of an address
+type Balances struct {
+	Address  string    `json:"address"`
+	Balances []Balance `json:"balances"`
+}
+
+// NewServer returns a server reading from the given database. Balances are listed for
+// the given contracts unless a request selects one.
+func NewServer(database db.Interface, contracts []string, logger logrus.FieldLogger) *Server {
+	s := &Server{db: database, contracts: contracts, logger: logger, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /events", s.handleEvents)
+	s.mux.HandleFunc("GET /events/{txHash}", s.handleTransaction)
+	s.mux.HandleFunc("GET /addresses/{address}/transfers", s.handleTransfers)
+	s.mux.HandleFunc("GET /addresses/{address}/balance", s.handleBalance)
+	return s
+}
+
+// ServeHTTP routes a request to its handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Run serves requests on addr until ctx is done, then waits briefly for the requests in
+// progress to complete.
+func (s *Server) Run(ctx context.Context, addr string) error {
+	server := &http.Server{Addr: addr, Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	errc := make(chan error, 1)
+	go func() { errc <- server.ListenAndServe() }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+// handleEvents lists the events matching the query parameters
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	query, err := eventQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.writeEvents(w, query)
+}
+
+// handleTransaction lists the events of a transaction
+func (s *Server) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	query, err := eventQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if query.TxHash, err = txHash(r.PathValue("txHash")); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page, ok := s.queryEvents(w, query)
+	if !ok {
+		return
+	}
+	if len(page.Events) == 0 && query.Cursor == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no events indexed for transaction %s", query.TxHash))
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleTransfers lists the transfers, mints and burns sent or received by an address
+func (s *Server) handleTransfers(w http.ResponseWriter, r *http.Request) {
+	query, err := eventQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if query.Address, err = address(r.PathValue("address")); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	for _, eventType := range query.EventTypes {
+		if !slices.Contains(transferTypes, eventType) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%s is not a transfer type", eventType))
+			return
+		}
+	}
+	if len(query.EventTypes) == 0 {
+		query.EventTypes = transferTypes
+	}
+	s.writeEvents(w, query)
+}
+
+// handleBalance returns the balances of an address in the tracked contracts, or in the
+// contract of the contract parameter
+func (s *Server) handleBalance(w http.ResponseWriter, r *http.Request) {
+	holder, err := address(r.PathValue("address"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	contracts := s.contracts
+	if contract := r.URL.Query().Get("contract"); contract != "" {
+		if contract, err = address(contract); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		contracts = []string{contract}
+	}
+
+	balances := Balances{Address: holder, Balances: make([]Balance, 0, len(contracts))}
+	for _, contract := range contracts {
+		balance, err := s.db.GetBalance(contract, holder)
+		if err != nil {
+			s.internalError(w, fmt.Errorf("failed to get the balance of %s in %s: %v", holder, contract, err))
+			return
+		}
+		balances.Balances = append(balances.Balances, Balance{Contract: contract, Balance: balance.String()})
+	}
+	writeJSON(w, http.StatusOK, balances)
+}
+
+// writeEvents writes the page of events matching a query
+func (s *Server) writeEvents(w http.ResponseWriter, query db.EventQuery) {
+	if page, ok := s.queryEvents(w, query); ok {
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// queryEvents returns the page of events matching a query, or writes the error and
+// returns false if the query fails
+func (s *Server) queryEvents(w http.ResponseWriter, query db.EventQuery) (EventPage, bool) {
+	result, err := s.db.QueryEvents(query)
+	if errors.Is(err, db.ErrInvalidCursor) {
+		writeError(w, http.StatusBadRequest, err)
+		return EventPage{}, false
+	}
+	if err != nil {
+		s.internalError(w, fmt.Errorf("failed to query events: %v", err))
+		return EventPage{}, false
+	}
+
+	page := EventPage{Events: make([]Event, len(result.Events)), NextCursor: result.NextCursor}
+	for i, e := range result.Events {
+		page.Events[i] = Event{
+			Contract:    e.ContractAddress,
+			BlockNumber: e.BlockNumber,
+			BlockHash:   e.BlockHash,
+			TxHash:      e.TxHash,
+			LogIndex:    e.LogIndex,
+			Type:        e.EventType,
+			From:        e.From,
+			To:          e.To,
+			Owner:       e.Owner,
+			Spender:     e.Spender,
+		}
+		if e.Value != nil {
+			value := e.Value.String()
+			page.Events[i].Value = &value
+		}
+	}
+	return page, true
+}
+
+// eventQuery reads the filters and page of an event list from the query parameters
+func eventQuery(r *http.Request) (db.EventQuery, error) {
+	params := r.URL.Query()
+	query := db.EventQuery{Cursor: params.Get("cursor")}
+
+	var err error
+	if query.ContractAddress, err = address(params.Get("contract")); err != nil {
+		return query, err
+	}
+	if query.Address, err = address(params.Get("address")); err != nil {
+		return query, err
+	}
+	if query.TxHash, err = txHash(params.Get("tx_hash")); err != nil {
+		return query, err
+	}
+	if types := params.Get("type"); types != "" {
+		query.EventTypes = strings.Split(types, ",")
+	}
+	if query.FromBlock, err = uintParam(params.Get("from_block"), "from_block"); err != nil {
+		return query, err
+	}
+	if query.ToBlock, err = uintParam(params.Get("to_block"), "to_block"); err != nil {
+		return query, err
+	}
+	if limit := params.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit <= 0 {
+			return query, fmt.Errorf("limit must be a positive number, got %q", limit)
+		}
+	}
+	return query, nil
+}
+
+// address returns the checksummed form of an address, as events are stored with, or an
+// empty string for an empty parameter
+func address(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	if !common.IsHexAddress(s) {
+		return "", fmt.Errorf("invalid address %q", s)
+	}
+	return common.HexToAddress(s).Hex(), nil
+}
+
+// txHash returns the lowercase form of a transaction hash, as events are stored with,
+// or an empty string for an empty parameter
+func txHash(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	hash, err := hexutil.Decode(s)
+	if err != nil || len(hash) != common.HashLength {
+		return "", fmt.Errorf("invalid transaction hash %q", s)
+	}
+	return common.BytesToHash(hash).Hex(), nil
+}
+
+// uintParam parses a block number parameter, or returns 0 for an empty parameter
+func uintParam(s, name string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a block number, got %q", name, s)
+	}
+	return n, nil
+}
+
+// internalError logs an error and reports it to the client without its details
+func (s *Server) internalError(w http.ResponseWriter, err error) {
+	s.logger.Errorf("API request failed: %v", err)
+	writeError(w, http.StatusInternalServerError, errors.New("internal error"))
+}
+
+// writeError writes an error as {"error": "..."}
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeJSON writes a value as the JSON body of a response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"go-contract-indexer/db"
+)
+
+// Addresses are stored checksummed, as the log handler formats them
+var (
+	usdc  = common.HexToAddress("0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48").Hex()
+	alice = common.HexToAddress("0x1234567890abcdef1234567890abcdef12345678").Hex()
+	bob   = common.HexToAddress("0x1234567890abcdef1234567890abcdef12345679").Hex()
+	zero  = common.Address{}.Hex()
+)
+
+const (
+	mintTx     = "0x1111111111111111111111111111111111111111111111111111111111111111"
+	transferTx = "0x2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+// newTestServer returns a server over a mint of 1000 to alice, an approval of bob and a
+// transfer of 250 from alice to bob in the same transaction
+func newTestServer(t *testing.T) *Server {
+	store := db.NewMemoryDB()
+	event := func(blockNumber uint64, txHash string, logIndex uint, eventType string, from, to, owner, spender *string, value *big.Int) db.Event {
+		return db.Event{
+			ContractAddress: usdc,
+			BlockNumber:     blockNumber,
+			BlockHash:       "0x" + strings.Repeat("0", 62) + "0a",
+			TxHash:          txHash,
+			LogIndex:        logIndex,
+			EventType:       eventType,
+			From:            from,
+			To:              to,
+			Owner:           owner,
+			Spender:         spender,
+			Value:           value,
+		}
+	}
+	from, to, owner, spender := zero, alice, alice, bob
+	supply, _ := new(big.Int).SetString("1000000000000000000000", 10)
+	assert.Nil(t, store.SaveEvents([]db.Event{
+		event(10, mintTx, 0, "Mint", &from, &to, nil, nil, supply),
+		event(11, transferTx, 0, "Approval", nil, nil, &owner, &spender, db.UnlimitedAllowance),
+		event(11, transferTx, 1, "Transfer", &owner, &spender, nil, nil, big.NewInt(250)),
+	}))
+	return NewServer(store, []string{usdc}, logrus.New())
+}
+
+// get serves a GET request and decodes its JSON response into v
+func get(t *testing.T, s *Server, url string, v any) int {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), v), rec.Body.String())
+	return rec.Code
+}
+
+func TestEvents(t *testing.T) {
+	s := newTestServer(t)
+
+	var page EventPage
+	assert.Equal(t, http.StatusOK, get(t, s, "/events", &page))
+	if assert.Len(t, page.Events, 3) {
+		mint := page.Events[0]
+		assert.Equal(t, usdc, mint.Contract)
+		assert.Equal(t, uint64(10), mint.BlockNumber)
+		assert.Equal(t, "Mint", mint.Type)
+		assert.Equal(t, alice, *mint.To)
+		assert.Nil(t, mint.Owner)
+		assert.Equal(t, "1000000000000000000000", *mint.Value, "Amounts should be decimal strings")
+		assert.Equal(t, db.UnlimitedAllowance.String(), *page.Events[1].Value)
+	}
+	assert.Empty(t, page.NextCursor)
+
+	// Pages continue from the cursor of the previous page
+	var first, second EventPage
+	assert.Equal(t, http.StatusOK, get(t, s, "/events?limit=2", &first))
+	assert.Len(t, first.Events, 2)
+	assert.NotEmpty(t, first.NextCursor)
+	assert.Equal(t, http.StatusOK, get(t, s, "/events?limit=2&cursor="+first.NextCursor, &second))
+	if assert.Len(t, second.Events, 1) {
+		assert.Equal(t, "Transfer", second.Events[0].Type)
+	}
+	assert.Empty(t, second.NextCursor)
+
+	// Addresses are matched regardless of their case
+	assert.Equal(t, http.StatusOK, get(t, s, "/events?address="+strings.ToLower(bob), &page))
+	assert.Len(t, page.Events, 2)
+	assert.Equal(t, http.StatusOK, get(t, s, "/events?type=Mint,Approval&from_block=11", &page))
+	assert.Len(t, page.Events, 1)
+	assert.Equal(t, http.StatusOK, get(t, s, "/events?tx_hash="+mintTx+"&contract="+usdc, &page))
+	assert.Len(t, page.Events, 1)
+	assert.Equal(t, http.StatusOK, get(t, s, "/events?to_block=9", &page))
+	assert.NotNil(t, page.Events, "Empty pages should list no events rather than null")
+	assert.Empty(t, page.Events)
+
+	var errorBody map[string]string
+	for _, url := range []string{
+		"/events?cursor=invalid",
+		"/events?limit=0",
+		"/events?from_block=latest",
+		"/events?address=0x1234",
+		"/events?tx_hash=0x1234",
+	} {
+		assert.Equal(t, http.StatusBadRequest, get(t, s, url, &errorBody), url)
+		assert.NotEmpty(t, errorBody["error"])
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	s := newTestServer(t)
+
+	var page EventPage
+	assert.Equal(t, http.StatusOK, get(t, s, "/events/"+transferTx, &page))
+	if assert.Len(t, page.Events, 2) {
+		assert.Equal(t, "Approval", page.Events[0].Type)
+		assert.Equal(t, transferTx, page.Events[1].TxHash)
+	}
+
+	var errorBody map[string]string
+	assert.Equal(t, http.StatusNotFound, get(t, s, "/events/0x"+strings.Repeat("3", 64), &errorBody))
+	assert.Equal(t, http.StatusBadRequest, get(t, s, "/events/0xnothex", &errorBody))
+}
+
+func TestTransfers(t *testing.T) {
+	s := newTestServer(t)
+
+	var page EventPage
+	assert.Equal(t, http.StatusOK, get(t, s, "/addresses/"+alice+"/transfers", &page))
+	if assert.Len(t, page.Events, 2, "Approvals are not transfers") {
+		assert.Equal(t, "Mint", page.Events[0].Type)
+		assert.Equal(t, "Transfer", page.Events[1].Type)
+	}
+	assert.Equal(t, http.StatusOK, get(t, s, "/addresses/"+alice+"/transfers?type=Mint", &page))
+	assert.Len(t, page.Events, 1)
+
+	var errorBody map[string]string
+	assert.Equal(t, http.StatusBadRequest, get(t, s, "/addresses/"+alice+"/transfers?type=Approval", &errorBody))
+	assert.Equal(t, http.StatusBadRequest, get(t, s, "/addresses/alice/transfers", &errorBody))
+}
+
+func TestBalance(t *testing.T) {
+	s := newTestServer(t)
+
+	var balances Balances
+	assert.Equal(t, http.StatusOK, get(t, s, "/addresses/"+strings.ToLower(alice)+"/balance", &balances))
+	assert.Equal(t, alice, balances.Address)
+	assert.Equal(t, []Balance{{Contract: usdc, Balance: "999999999999999999750"}}, balances.Balances)
+
+	other := "0x6B175474E89094C44Da98b954EedeAC495271d0F"
+	assert.Equal(t, http.StatusOK, get(t, s, "/addresses/"+bob+"/balance?contract="+other, &balances))
+	assert.Equal(t, []Balance{{Contract: other, Balance: "0"}}, balances.Balances)
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestServer(t).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/events", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- newTestServer(t).Run(ctx, "127.0.0.1:0") }()
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.Nil(t, err, "The server should shut down cleanly")
+	case <-time.After(5 * time.Second):
+		t.Fatal("The server did not stop")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/viper"
+
+	"go-contract-indexer/db"
+	"go-contract-indexer/ingest"
+	"go-contract-indexer/loghandler"
+)
+
+// endpointConfig is an entry of the RPC_ENDPOINTS list.
+type endpointConfig struct {
+	URL      string `mapstructure:"url"`
+	Priority int    `mapstructure:"priority"`
+}
+
+// contractConfig is an entry of the CONTRACTS list.
+type contractConfig struct {
+	Address    string  `mapstructure:"address"`
+	Label      string  `mapstructure:"label"`
+	StartBlock *uint64 `mapstructure:"start_block"`
+}
+
+func validateConfig() error {
+	if viper.GetString("RPC_URL") == "" && !viper.IsSet("RPC_ENDPOINTS") {
+		return errors.New("RPC_URL or RPC_ENDPOINTS is required")
+	}
+	if _, err := loadEndpoints(); err != nil {
+		return err
+	}
+	if viper.GetString("CONTRACT_ADDRESS") == "" && !viper.IsSet("CONTRACTS") {
+		return errors.New("CONTRACT_ADDRESS or CONTRACTS is required")
+	}
+	if _, err := loadContracts(); err != nil {
+		return err
+	}
+	if viper.GetString("DB_CONN_STR") == "" {
+		return errors.New("DB_CONN_STR is required")
+	}
+	if _, err := loghandler.ParseFinality(viper.GetString("FINALITY")); err != nil {
+		return err
+	}
+	return nil
+}
+
+// loadEndpoints returns the RPC_ENDPOINTS list, or RPC_URL as the only endpoint if it is not set.
+func loadEndpoints() ([]endpointConfig, error) {
+	if !viper.IsSet("RPC_ENDPOINTS") {
+		return []endpointConfig{{URL: viper.GetString("RPC_URL")}}, nil
+	}
+
+	var endpoints []endpointConfig
+	if err := viper.UnmarshalKey("RPC_ENDPOINTS", &endpoints); err != nil {
+		return nil, fmt.Errorf("failed to parse RPC_ENDPOINTS: %v", err)
+	}
+	if len(endpoints) == 0 {
+		return nil, errors.New("RPC_ENDPOINTS is empty")
+	}
+	for i, e := range endpoints {
+		if e.URL == "" {
+			return nil, fmt.Errorf("RPC_ENDPOINTS entry %d has no url", i)
+		}
+	}
+	return endpoints, nil
+}
+
+// requiresPolling reports whether none of the endpoints support log subscriptions.
+func requiresPolling(endpoints []endpointConfig) bool {
+	for _, e := range endpoints {
+		if !ingest.RequiresPolling(e.URL) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadContracts returns the CONTRACTS list, or CONTRACT_ADDRESS with START_BLOCK as the
+// only contract if it is not set.
+func loadContracts() ([]contractConfig, error) {
+	var contracts []contractConfig
+	if viper.IsSet("CONTRACTS") {
+		if err := viper.UnmarshalKey("CONTRACTS", &contracts); err != nil {
+			return nil, fmt.Errorf("failed to parse CONTRACTS: %v", err)
+		}
+		if len(contracts) == 0 {
+			return nil, errors.New("CONTRACTS is empty")
+		}
+	} else {
+		contract := contractConfig{Address: viper.GetString("CONTRACT_ADDRESS")}
+		if viper.IsSet("START_BLOCK") {
+			startBlock := viper.GetUint64("START_BLOCK")
+			contract.StartBlock = &startBlock
+		}
+		contracts = append(contracts, contract)
+	}
+
+	seen := make(map[common.Address]bool)
+	for i, c := range contracts {
+		if !common.IsHexAddress(c.Address) {
+			return nil, fmt.Errorf("contract %d has an invalid address %q", i, c.Address)
+		}
+		address := common.HexToAddress(c.Address)
+		if seen[address] {
+			return nil, fmt.Errorf("contract %s is listed more than once", address.Hex())
+		}
+		seen[address] = true
+	}
+	return contracts, nil
+}
+
+// resolveStartBlocks returns the first block to index for each contract: its stored
+// checkpoint if there is one, otherwise its configured start block, otherwise the block
+// after the current head. The checkpoint block itself is indexed again, as it may have
+// been only partially stored; its logs that were already stored are skipped.
+func resolveStartBlocks(database db.Interface, contracts []contractConfig, head uint64) (map[common.Address]uint64, error) {
+	startBlocks := make(map[common.Address]uint64)
+	for _, c := range contracts {
+		address := common.HexToAddress(c.Address)
+		checkpoint, ok, err := database.GetCheckpoint(address.Hex())
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case ok:
+			startBlocks[address] = checkpoint
+		case c.StartBlock != nil:
+			startBlocks[address] = *c.StartBlock
+		default:
+			startBlocks[address] = head + 1
+		}
+	}
+	return startBlocks, nil
+}
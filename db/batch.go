@@ -0,0 +1,351 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// insertBatchSize is the number of rows per INSERT statement. Larger statements save
+// few round trips and take SQLite quadratically longer to bind.
+const insertBatchSize = 50
+
+// SaveEvents saves events to the database with multi-row inserts and advances the sync
+// checkpoints of their contracts, all in a single transaction. Balances are updated once
+// per holder rather than once per transfer. Events already stored are skipped without
+// changing balances, allowances or supplies again, so overlapping backfills are safe.
+func (db *DB) SaveEvents(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	inserted := make(map[eventKey]bool)
+	for start := 0; start < len(events); start += insertBatchSize {
+		end := start + insertBatchSize
+		if end > len(events) {
+			end = len(events)
+		}
+		if err = insertEvents(tx, events[start:end], inserted); err != nil {
+			return err
+		}
+	}
+
+	deltas := make(map[[2]string]*big.Int)
+	addDelta := func(contractAddress string, holder *string, amount *big.Int) {
+		if holder == nil || *holder == zeroAddress {
+			return
+		}
+		key := [2]string{contractAddress, *holder}
+		if _, ok := deltas[key]; !ok {
+			deltas[key] = new(big.Int)
+		}
+		deltas[key].Add(deltas[key], amount)
+	}
+
+	checkpoints := make(map[string]uint64)
+	for _, event := range events {
+		if event.BlockNumber >= checkpoints[event.ContractAddress] {
+			checkpoints[event.ContractAddress] = event.BlockNumber
+		}
+
+		// Only the first copy of an event that was not stored before is applied
+		key := eventKey{event.BlockHash, event.TxHash, event.LogIndex}
+		if !inserted[key] {
+			continue
+		}
+		delete(inserted, key)
+
+		switch {
+		case isTransfer(event.EventType) && event.Value != nil:
+			addDelta(event.ContractAddress, event.From, new(big.Int).Neg(event.Value))
+			addDelta(event.ContractAddress, event.To, event.Value)
+			if event.EventType == "Mint" || event.EventType == "Burn" {
+				if err = updateSupply(tx, event.ContractAddress, event.BlockNumber, event.EventType, event.Value); err != nil {
+					return err
+				}
+			}
+			if event.From != nil && event.Spender != nil {
+				if err = spendAllowance(tx, event.ContractAddress, *event.From, *event.Spender, event.Value, event.BlockNumber, event.TxHash); err != nil {
+					return err
+				}
+			}
+		case event.EventType == "Approval" && event.Value != nil && event.Owner != nil && event.Spender != nil:
+			if err = setAllowance(tx, event.ContractAddress, *event.Owner, *event.Spender, event.Value, event.BlockNumber, event.TxHash); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Apply the balance changes in a stable order, so concurrent writers lock rows alike
+	keys := make([][2]string, 0, len(deltas))
+	for key := range deltas {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		if deltas[key].Sign() == 0 {
+			continue
+		}
+		if err = addBalance(tx, key[0], key[1], deltas[key]); err != nil {
+			return err
+		}
+	}
+
+	for contractAddress, blockNumber := range checkpoints {
+		if err = advanceCheckpoint(tx, contractAddress, blockNumber); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// eventKey identifies a log of the chain
+type eventKey struct {
+	blockHash string
+	txHash    string
+	logIndex  uint
+}
+
+// insertEvents inserts events into erc20_events with a single statement, skipping those
+// already stored, and adds the keys of the inserted events to inserted
+func insertEvents(tx *sql.Tx, events []Event, inserted map[eventKey]bool) error {
+	const columns = 11
+	var query strings.Builder
+	query.WriteString(`INSERT INTO erc20_events (contract_address, block_number, block_hash, tx_hash, log_index, event_type, from_address, to_address, owner_address, spender_address, value) VALUES `)
+	args := make([]interface{}, 0, len(events)*columns)
+	for i, event := range events {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(")
+		for c := 1; c <= columns; c++ {
+			if c > 1 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "$%d", i*columns+c)
+		}
+		query.WriteString(")")
+
+		var value *string
+		if event.Value != nil {
+			v := event.Value.String()
+			value = &v
+		}
+		args = append(args, event.ContractAddress, event.BlockNumber, event.BlockHash, event.TxHash, event.LogIndex, event.EventType, event.From, event.To, event.Owner, event.Spender, value)
+	}
+	query.WriteString(` ON CONFLICT (block_hash, tx_hash, log_index) DO NOTHING RETURNING block_hash, tx_hash, log_index`)
+
+	rows, err := tx.Query(query.String(), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key eventKey
+		if err := rows.Scan(&key.blockHash, &key.txHash, &key.logIndex); err != nil {
+			return err
+		}
+		inserted[key] = true
+	}
+	return rows.Err()
+}
+
+// BatchWriter buffers events and saves them with SaveEvents once maxSize events are
+// buffered or maxDelay has passed since the first of them. Every other call flushes the
+// buffer first, so reads see the buffered events and checkpoints only advance past
+// events that are stored. A batch that fails to save stays buffered and is retried by
+// the next flush, so no event is lost and the checkpoint never skips over it.
+type BatchWriter struct {
+	Interface
+
+	maxSize int
+	mu      sync.Mutex
+	pending []Event
+	timer   *time.Timer
+	delay   time.Duration
+	// failed is set when a flush failed, so the next SaveEvent retries it.
+	failed bool
+}
+
+// NewBatchWriter wraps a database so events are saved in batches.
+func NewBatchWriter(db Interface, maxSize int, maxDelay time.Duration) *BatchWriter {
+	return &BatchWriter{Interface: db, maxSize: maxSize, delay: maxDelay}
+}
+
+// SaveEvent buffers an event. The buffer is saved once it is full, or right away while
+// the last flush has failed, so a failure of a background flush is reported by the
+// next SaveEvent rather than an unrelated call.
+func (w *BatchWriter) SaveEvent(event Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = append(w.pending, event)
+	if len(w.pending) >= w.maxSize || w.failed {
+		return w.flush()
+	}
+	w.schedule()
+	return nil
+}
+
+// schedule starts the timer of a background flush if none is running. The lock must be held.
+func (w *BatchWriter) schedule() {
+	if w.timer != nil || w.delay <= 0 {
+		return
+	}
+	w.timer = time.AfterFunc(w.delay, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.timer = nil
+		// A failure reschedules the timer and is reported by the next SaveEvent
+		_ = w.flush()
+	})
+}
+
+// SaveEvents buffers events like SaveEvent.
+func (w *BatchWriter) SaveEvents(events []Event) error {
+	for _, event := range events {
+		if err := w.SaveEvent(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush saves the buffered events.
+func (w *BatchWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flush()
+}
+
+// flush saves the buffered events. If that fails they stay buffered and a background
+// flush is scheduled to retry them. The lock must be held.
+func (w *BatchWriter) flush() error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if len(w.pending) == 0 {
+		return nil
+	}
+	if err := w.Interface.SaveEvents(w.pending); err != nil {
+		w.failed = true
+		w.schedule()
+		return err
+	}
+	w.pending = nil
+	w.failed = false
+	return nil
+}
+
+// The methods below flush the buffered events before calling the database.
+
+func (w *BatchWriter) GetBalance(contractAddress, holder string) (*big.Int, error) {
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return w.Interface.GetBalance(contractAddress, holder)
+}
+
+func (w *BatchWriter) ReplayBalances(contractAddress string, toBlock uint64) (map[string]*big.Int, error) {
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return w.Interface.ReplayBalances(contractAddress, toBlock)
+}
+
+func (w *BatchWriter) GetAllowance(contractAddress, owner, spender string) (*big.Int, error) {
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return w.Interface.GetAllowance(contractAddress, owner, spender)
+}
+
+func (w *BatchWriter) GetUnlimitedApprovals(owner string) ([]Allowance, error) {
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return w.Interface.GetUnlimitedApprovals(owner)
+}
+
+func (w *BatchWriter) GetTotalSupply(contractAddress string) (uint64, *big.Int, bool, error) {
+	if err := w.Flush(); err != nil {
+		return 0, nil, false, err
+	}
+	return w.Interface.GetTotalSupply(contractAddress)
+}
+
+func (w *BatchWriter) SaveContractEvent(event ContractEvent) error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return w.Interface.SaveContractEvent(event)
+}
+
+func (w *BatchWriter) SaveNFTEvents(events []NFTEvent) error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return w.Interface.SaveNFTEvents(events)
+}
+
+func (w *BatchWriter) QuarantineLog(log QuarantinedLog) error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return w.Interface.QuarantineLog(log)
+}
+
+func (w *BatchWriter) GetCheckpoint(contractAddress string) (uint64, bool, error) {
+	if err := w.Flush(); err != nil {
+		return 0, false, err
+	}
+	return w.Interface.GetCheckpoint(contractAddress)
+}
+
+func (w *BatchWriter) Rollback(fromBlock uint64) error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return w.Interface.Rollback(fromBlock)
+}
+
+func (w *BatchWriter) QueryEvents(query EventQuery) (EventPage, error) {
+	if err := w.Flush(); err != nil {
+		return EventPage{}, err
+	}
+	return w.Interface.QueryEvents(query)
+}
+
+// Close flushes the buffered events and closes the database.
+func (w *BatchWriter) Close() error {
+	w.mu.Lock()
+	flushErr := w.flush()
+	// Stop retrying a failed flush against the closed database
+	w.delay = 0
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.mu.Unlock()
+	if err := w.Interface.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countEvents returns the number of rows in erc20_events
+func countEvents(t testing.TB, db *DB) int {
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM erc20_events`).Scan(&count)
+	assert.Nil(t, err, "Error should be nil")
+	return count
+}
+
+func TestSaveEvents(t *testing.T) {
+	db := InitTestDB()
+
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	var events []Event
+	for i := uint64(0); i < 120; i++ {
+		events = append(events, testTransfer(contractAddress, 100+i))
+	}
+	assert.Nil(t, db.SaveEvents(events))
+	assert.Equal(t, 120, countEvents(t, db), "Batches larger than one statement should be split")
+
+	checkpoint, _, err := db.GetCheckpoint(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, uint64(219), checkpoint)
+
+	balance, err := db.GetBalance(contractAddress, *events[0].To)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "120", balance.String())
+	balance, err = db.GetBalance(contractAddress, *events[0].From)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "-120", balance.String())
+
+	assert.Nil(t, db.SaveEvents(nil))
+}
+
+func TestBatchWriter(t *testing.T) {
+	db := InitTestDB()
+	writer := NewBatchWriter(db, 3, time.Hour)
+
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	assert.Nil(t, writer.SaveEvent(testTransfer(contractAddress, 1)))
+	assert.Nil(t, writer.SaveEvent(testTransfer(contractAddress, 2)))
+	assert.Equal(t, 0, countEvents(t, db), "Events should be buffered")
+
+	assert.Nil(t, writer.SaveEvent(testTransfer(contractAddress, 3)))
+	assert.Equal(t, 3, countEvents(t, db), "Events should be flushed once the batch is full")
+
+	assert.Nil(t, writer.SaveEvent(testTransfer(contractAddress, 4)))
+	checkpoint, _, err := writer.GetCheckpoint(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, uint64(4), checkpoint, "Reads should flush the buffered events")
+
+	assert.Nil(t, writer.SaveEvent(testTransfer(contractAddress, 5)))
+	assert.Nil(t, writer.Rollback(5))
+	assert.Equal(t, 4, countEvents(t, db), "Rollbacks should apply to the buffered events")
+}
+
+func TestBatchWriter_Delay(t *testing.T) {
+	db := InitTestDB()
+	writer := NewBatchWriter(db, 100, 10*time.Millisecond)
+
+	assert.Nil(t, writer.SaveEvent(testTransfer("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", 1)))
+	assert.Eventually(t, func() bool {
+		writer.mu.Lock()
+		defer writer.mu.Unlock()
+		return countEvents(t, db) == 1
+	}, time.Second, 5*time.Millisecond, "Events should be flushed after the delay")
+}
+
+// failingDB fails SaveEvents while fail is set
+type failingDB struct {
+	*DB
+	mu   sync.Mutex
+	fail bool
+}
+
+func (f *failingDB) setFail(fail bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fail = fail
+}
+
+func (f *failingDB) SaveEvents(events []Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail {
+		return errors.New("database is locked")
+	}
+	return f.DB.SaveEvents(events)
+}
+
+func TestBatchWriter_FailedFlush(t *testing.T) {
+	db := &failingDB{DB: InitTestDB(), fail: true}
+	writer := NewBatchWriter(db, 2, time.Hour)
+
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	assert.Nil(t, writer.SaveEvent(testTransfer(contractAddress, 1)))
+	assert.Error(t, writer.SaveEvent(testTransfer(contractAddress, 2)))
+	_, _, err := writer.GetCheckpoint(contractAddress)
+	assert.Error(t, err, "Reads should fail while the buffered events cannot be saved")
+
+	db.setFail(false)
+	assert.Nil(t, writer.SaveEvent(testTransfer(contractAddress, 3)), "The failed batch should be retried")
+	assert.Equal(t, 3, countEvents(t, db.DB), "Events of a failed batch should not be lost")
+
+	checkpoint, _, err := writer.GetCheckpoint(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, uint64(3), checkpoint)
+}
+
+func TestBatchWriter_FailedDelayedFlush(t *testing.T) {
+	db := &failingDB{DB: InitTestDB(), fail: true}
+	writer := NewBatchWriter(db, 100, 10*time.Millisecond)
+
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	assert.Nil(t, writer.SaveEvent(testTransfer(contractAddress, 1)))
+	assert.Eventually(t, func() bool {
+		writer.mu.Lock()
+		defer writer.mu.Unlock()
+		return writer.failed
+	}, time.Second, 5*time.Millisecond, "The background flush should fail")
+
+	// The next write retries the failed batch and reports its error
+	assert.Error(t, writer.SaveEvent(testTransfer(contractAddress, 2)))
+
+	// The timer keeps retrying the buffered events once the database recovers
+	db.setFail(false)
+	assert.Eventually(t, func() bool {
+		writer.mu.Lock()
+		defer writer.mu.Unlock()
+		return countEvents(t, db.DB) == 2
+	}, time.Second, 5*time.Millisecond, "Events of a failed background flush should not be lost")
+}
+
+func benchmarkTransfers(n int) []Event {
+	events := make([]Event, n)
+	for i := range events {
+		from := fmt.Sprintf("0x%040x", i%100+1)
+		to := fmt.Sprintf("0x%040x", (i+1)%100+1)
+		events[i] = Event{
+			ContractAddress: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48",
+			BlockNumber:     uint64(i),
+			BlockHash:       testBlockHash(uint64(i)),
+			TxHash:          "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+			EventType:       "Transfer",
+			From:            &from,
+			To:              &to,
+			Value:           big.NewInt(1),
+		}
+	}
+	return events
+}
+
+func BenchmarkSaveEvent(b *testing.B) {
+	db := InitTestDB()
+	events := benchmarkTransfers(b.N)
+	b.ResetTimer()
+	for _, event := range events {
+		if err := db.SaveEvent(event); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSaveEvents(b *testing.B) {
+	db := InitTestDB()
+	events := benchmarkTransfers(b.N)
+	b.ResetTimer()
+	// Save the events in batches of BATCH_SIZE's default
+	for start := 0; start < len(events); start += 500 {
+		end := start + 500
+		if end > len(events) {
+			end = len(events)
+		}
+		if err := db.SaveEvents(events[start:end]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,831 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltScheme prefixes the connection strings of embedded bbolt databases
+const boltScheme = "bolt://"
+
+// Buckets of a BoltDB, named after the tables of DB
+var (
+	eventsBucket         = []byte("erc20_events")
+	addressIndexBucket   = []byte("erc20_events_by_address")
+	txIndexBucket        = []byte("erc20_events_by_tx")
+	contractEventsBucket = []byte("contract_events")
+	nftEventsBucket      = []byte("nft_events")
+	quarantineBucket     = []byte("quarantined_logs")
+	tokensBucket         = []byte("tokens")
+	checkpointsBucket    = []byte("sync_checkpoint")
+	blocksBucket         = []byte("blocks")
+	balancesBucket       = []byte("balances")
+	allowancesBucket     = []byte("allowances")
+	supplyBucket         = []byte("token_supply")
+	ownersBucket         = []byte("nft_owners")
+	nftBalancesBucket    = []byte("nft_balances")
+)
+
+// BoltDB is an Interface stored in an embedded bbolt file, for single node deployments
+// without a database server. Logs are keyed by block number, log index, block hash and
+// transaction hash, so they sort in chain order and blocks are scanned or rolled back as
+// ranges. ERC-20 events are also indexed by the addresses they involve and by transaction.
+//
+// Allowances, token owners and total supplies are kept as histories keyed by the
+// position of the event that changed them, the last entry being the current value, so a
+// rollback only has to delete the entries of the removed events.
+type BoltDB struct {
+	db *bolt.DB
+}
+
+// boltAllowance is an entry of the allowance history of an owner and spender
+type boltAllowance struct {
+	Amount      *big.Int
+	BlockNumber uint64
+	TxHash      string
+}
+
+// OpenBolt opens the bbolt database at the given path, creating it if it does not exist
+func OpenBolt(path string) (*BoltDB, error) {
+	// The file is locked while open, wait briefly for another process to release it
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%s is in use by another process", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{
+			eventsBucket, addressIndexBucket, txIndexBucket, contractEventsBucket, nftEventsBucket,
+			quarantineBucket, tokensBucket, checkpointsBucket, blocksBucket, balancesBucket,
+			allowancesBucket, supplyBucket, ownersBucket, nftBalancesBucket,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltDB{db: db}, nil
+}
+
+// logKey returns the key of a log, which sorts logs by block number and log index
+func logKey(blockNumber uint64, logIndex uint, blockHash, txHash string) []byte {
+	key := binary.BigEndian.AppendUint64(nil, blockNumber)
+	key = binary.BigEndian.AppendUint32(key, uint32(logIndex))
+	key = append(key, blockHash...)
+	key = append(key, 0)
+	return append(key, txHash...)
+}
+
+// nftKey returns the key of one of the events decoded from the log of a non-fungible token
+func nftKey(event NFTEvent, batchIndex int) []byte {
+	key := logKey(event.BlockNumber, event.LogIndex, event.BlockHash, event.TxHash)
+	key = append(key, 0)
+	return binary.BigEndian.AppendUint32(key, uint32(batchIndex))
+}
+
+// compositeKey joins fields into a key, terminating each with a zero byte so the key of
+// some fields is a prefix of the keys that extend them
+func compositeKey(fields ...string) []byte {
+	var key []byte
+	for _, field := range fields {
+		key = append(key, field...)
+		key = append(key, 0)
+	}
+	return key
+}
+
+// historyKey returns the key of a history entry changed by the event at the given position
+func historyKey(prefix []byte, blockNumber uint64, logIndex uint) []byte {
+	key := binary.BigEndian.AppendUint64(append([]byte(nil), prefix...), blockNumber)
+	return binary.BigEndian.AppendUint32(key, uint32(logIndex))
+}
+
+// allowanceKey returns the prefix of the allowance history of an owner and spender
+func allowanceKey(contractAddress, owner, spender string) []byte {
+	return compositeKey(owner, contractAddress, spender)
+}
+
+// lastWithPrefix returns the last entry of a bucket whose key starts with prefix, which
+// must end with the zero byte of a composite key
+func lastWithPrefix(b *bolt.Bucket, prefix []byte) ([]byte, []byte) {
+	end := append(prefix[:len(prefix)-1:len(prefix)-1], 1)
+	c := b.Cursor()
+	k, v := c.Seek(end)
+	if k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+	if k == nil || !bytes.HasPrefix(k, prefix) {
+		return nil, nil
+	}
+	return k, v
+}
+
+// bigValue parses an amount stored as a decimal string, or returns zero if there is none
+func bigValue(v []byte) *big.Int {
+	n, ok := new(big.Int).SetString(string(v), 10)
+	if !ok {
+		return new(big.Int)
+	}
+	return n
+}
+
+// addBoltBalance adds a signed amount to a stored balance, deleting empty balances
+func addBoltBalance(b *bolt.Bucket, key []byte, amount *big.Int) error {
+	balance := bigValue(b.Get(key))
+	balance.Add(balance, amount)
+	if balance.Sign() == 0 {
+		return b.Delete(key)
+	}
+	return b.Put(key, []byte(balance.String()))
+}
+
+// moveBoltBalance moves an amount of a token from one holder to another, skipping the
+// zero address
+func moveBoltBalance(tx *bolt.Tx, contractAddress string, from, to *string, amount *big.Int) error {
+	balances := tx.Bucket(balancesBucket)
+	if from != nil && *from != zeroAddress {
+		if err := addBoltBalance(balances, compositeKey(contractAddress, *from), new(big.Int).Neg(amount)); err != nil {
+			return err
+		}
+	}
+	if to != nil && *to != zeroAddress {
+		return addBoltBalance(balances, compositeKey(contractAddress, *to), amount)
+	}
+	return nil
+}
+
+// advanceBoltCheckpoint moves the sync checkpoint of a contract forward to the given block
+func advanceBoltCheckpoint(tx *bolt.Tx, contractAddress string, blockNumber uint64) error {
+	checkpoints := tx.Bucket(checkpointsBucket)
+	if v := checkpoints.Get([]byte(contractAddress)); v != nil && binary.BigEndian.Uint64(v) >= blockNumber {
+		return nil
+	}
+	return checkpoints.Put([]byte(contractAddress), binary.BigEndian.AppendUint64(nil, blockNumber))
+}
+
+// eventAddresses returns the distinct addresses an event is indexed by
+func eventAddresses(event Event) []string {
+	var addresses []string
+	for _, address := range []*string{event.From, event.To, event.Owner, event.Spender} {
+		if address == nil {
+			continue
+		}
+		duplicate := false
+		for _, a := range addresses {
+			duplicate = duplicate || a == *address
+		}
+		if !duplicate {
+			addresses = append(addresses, *address)
+		}
+	}
+	return addresses
+}
+
+// SaveEvent stores an event and advances the sync checkpoint of its contract
+func (b *BoltDB) SaveEvent(event Event) error {
+	return b.SaveEvents([]Event{event})
+}
+
+// SaveEvents stores events and advances the sync checkpoints of their contracts in one
+// transaction, skipping events already stored
+func (b *BoltDB) SaveEvents(events []Event) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		stored := tx.Bucket(eventsBucket)
+		index := tx.Bucket(addressIndexBucket)
+		txIndex := tx.Bucket(txIndexBucket)
+		for _, event := range events {
+			if err := advanceBoltCheckpoint(tx, event.ContractAddress, event.BlockNumber); err != nil {
+				return err
+			}
+			key := logKey(event.BlockNumber, event.LogIndex, event.BlockHash, event.TxHash)
+			if stored.Get(key) != nil {
+				continue
+			}
+
+			value, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if err := stored.Put(key, value); err != nil {
+				return err
+			}
+			for _, address := range eventAddresses(event) {
+				if err := index.Put(append(compositeKey(address), key...), []byte{}); err != nil {
+					return err
+				}
+			}
+			if err := txIndex.Put(append(compositeKey(event.TxHash), key...), []byte{}); err != nil {
+				return err
+			}
+			if err := applyBoltEvent(tx, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// applyBoltEvent updates the balances, supplies and allowances changed by an event
+func applyBoltEvent(tx *bolt.Tx, event Event) error {
+	switch {
+	case isTransfer(event.EventType) && event.Value != nil:
+		if err := moveBoltBalance(tx, event.ContractAddress, event.From, event.To, event.Value); err != nil {
+			return err
+		}
+		if event.EventType == "Mint" || event.EventType == "Burn" {
+			if err := updateBoltSupply(tx, event.ContractAddress, event.BlockNumber, event.EventType, event.Value); err != nil {
+				return err
+			}
+		}
+		if event.From != nil && event.Spender != nil {
+			return spendBoltAllowance(tx, event)
+		}
+	case event.EventType == "Approval" && event.Value != nil && event.Owner != nil && event.Spender != nil:
+		prefix := allowanceKey(event.ContractAddress, *event.Owner, *event.Spender)
+		return putAllowance(tx, historyKey(prefix, event.BlockNumber, event.LogIndex), boltAllowance{
+			Amount:      event.Value,
+			BlockNumber: event.BlockNumber,
+			TxHash:      event.TxHash,
+		})
+	}
+	return nil
+}
+
+// revertBoltEvent reverses the changes applyBoltEvent made for an event
+func revertBoltEvent(tx *bolt.Tx, event Event) error {
+	switch {
+	case isTransfer(event.EventType) && event.Value != nil:
+		if err := moveBoltBalance(tx, event.ContractAddress, event.To, event.From, event.Value); err != nil {
+			return err
+		}
+		if event.EventType == "Mint" || event.EventType == "Burn" {
+			key := binary.BigEndian.AppendUint64(compositeKey(event.ContractAddress), event.BlockNumber)
+			if err := tx.Bucket(supplyBucket).Delete(key); err != nil {
+				return err
+			}
+		}
+		if event.From != nil && event.Spender != nil {
+			prefix := allowanceKey(event.ContractAddress, *event.From, *event.Spender)
+			return tx.Bucket(allowancesBucket).Delete(historyKey(prefix, event.BlockNumber, event.LogIndex))
+		}
+	case event.EventType == "Approval" && event.Owner != nil && event.Spender != nil:
+		prefix := allowanceKey(event.ContractAddress, *event.Owner, *event.Spender)
+		return tx.Bucket(allowancesBucket).Delete(historyKey(prefix, event.BlockNumber, event.LogIndex))
+	}
+	return nil
+}
+
+// updateBoltSupply records the total supply of a token after a mint or burn in the given block
+func updateBoltSupply(tx *bolt.Tx, contractAddress string, blockNumber uint64, eventType string, amount *big.Int) error {
+	supplies := tx.Bucket(supplyBucket)
+	prefix := compositeKey(contractAddress)
+	supply := new(big.Int)
+	if _, v := lastWithPrefix(supplies, prefix); v != nil {
+		supply = bigValue(v)
+	}
+	if eventType == "Mint" {
+		supply.Add(supply, amount)
+	} else {
+		supply.Sub(supply, amount)
+	}
+	return supplies.Put(binary.BigEndian.AppendUint64(prefix, blockNumber), []byte(supply.String()))
+}
+
+// currentAllowance returns the last entry of the allowance history of an owner and spender
+func currentAllowance(tx *bolt.Tx, prefix []byte) (boltAllowance, bool, error) {
+	var allowance boltAllowance
+	_, v := lastWithPrefix(tx.Bucket(allowancesBucket), prefix)
+	if v == nil {
+		return allowance, false, nil
+	}
+	err := json.Unmarshal(v, &allowance)
+	return allowance, err == nil, err
+}
+
+// putAllowance appends an entry to an allowance history
+func putAllowance(tx *bolt.Tx, key []byte, allowance boltAllowance) error {
+	value, err := json.Marshal(allowance)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(allowancesBucket).Put(key, value)
+}
+
+// spendBoltAllowance deducts a transferFrom from the allowance of its caller, unless the
+// allowance is unlimited or was set by an Approval in the same transaction
+func spendBoltAllowance(tx *bolt.Tx, event Event) error {
+	prefix := allowanceKey(event.ContractAddress, *event.From, *event.Spender)
+	allowance, ok, err := currentAllowance(tx, prefix)
+	if err != nil || !ok || allowance.TxHash == event.TxHash || allowance.Amount.Cmp(UnlimitedAllowance) == 0 {
+		return err
+	}
+	remaining := new(big.Int).Sub(allowance.Amount, event.Value)
+	if remaining.Sign() < 0 {
+		remaining.SetInt64(0)
+	}
+	return putAllowance(tx, historyKey(prefix, event.BlockNumber, event.LogIndex), boltAllowance{
+		Amount:      remaining,
+		BlockNumber: event.BlockNumber,
+		TxHash:      allowance.TxHash,
+	})
+}
+
+// GetBalance returns the balance of a token held by an address
+func (b *BoltDB) GetBalance(contractAddress, holder string) (*big.Int, error) {
+	balance := new(big.Int)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		balance = bigValue(tx.Bucket(balancesBucket).Get(compositeKey(contractAddress, holder)))
+		return nil
+	})
+	return balance, err
+}
+
+// ReplayBalances recomputes the balances of a token from its Transfer events up to and
+// including the given block
+func (b *BoltDB) ReplayBalances(contractAddress string, toBlock uint64) (map[string]*big.Int, error) {
+	balances := make(map[string]*big.Int)
+	add := func(holder *string, amount *big.Int) {
+		if holder == nil || *holder == zeroAddress {
+			return
+		}
+		balance, ok := balances[*holder]
+		if !ok {
+			balance = new(big.Int)
+			balances[*holder] = balance
+		}
+		balance.Add(balance, amount)
+	}
+
+	err := b.scanEvents(0, toBlock, func(event Event) error {
+		if event.ContractAddress == contractAddress && isTransfer(event.EventType) && event.Value != nil {
+			add(event.From, new(big.Int).Neg(event.Value))
+			add(event.To, event.Value)
+		}
+		return nil
+	})
+	return balances, err
+}
+
+// GetAllowance returns the amount a spender may still transfer on behalf of an owner
+func (b *BoltDB) GetAllowance(contractAddress, owner, spender string) (*big.Int, error) {
+	amount := new(big.Int)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		allowance, ok, err := currentAllowance(tx, allowanceKey(contractAddress, owner, spender))
+		if ok {
+			amount = allowance.Amount
+		}
+		return err
+	})
+	return amount, err
+}
+
+// GetUnlimitedApprovals returns the unlimited allowances granted by an owner
+func (b *BoltDB) GetUnlimitedApprovals(owner string) ([]Allowance, error) {
+	var allowances []Allowance
+	err := b.db.View(func(tx *bolt.Tx) error {
+		prefix := compositeKey(owner)
+		var history, current []byte
+		add := func() error {
+			var allowance boltAllowance
+			if err := json.Unmarshal(current, &allowance); err != nil {
+				return err
+			}
+			if allowance.Amount.Cmp(UnlimitedAllowance) != 0 {
+				return nil
+			}
+			fields := bytes.Split(history[len(prefix):], []byte{0})
+			allowances = append(allowances, Allowance{
+				ContractAddress: string(fields[0]),
+				Owner:           owner,
+				Spender:         string(fields[1]),
+				Amount:          allowance.Amount,
+				BlockNumber:     allowance.BlockNumber,
+			})
+			return nil
+		}
+
+		// The last entry of each history is the current allowance of its spender
+		c := tx.Bucket(allowancesBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if history != nil && !bytes.Equal(history, k[:len(k)-12]) {
+				if err := add(); err != nil {
+					return err
+				}
+			}
+			history, current = append([]byte(nil), k[:len(k)-12]...), append([]byte(nil), v...)
+		}
+		if history == nil {
+			return nil
+		}
+		return add()
+	})
+	return allowances, err
+}
+
+// GetTotalSupply returns the latest total supply of a token and the block it changed in,
+// or false if no mint or burn has been stored
+func (b *BoltDB) GetTotalSupply(contractAddress string) (uint64, *big.Int, bool, error) {
+	var blockNumber uint64
+	var supply *big.Int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		prefix := compositeKey(contractAddress)
+		k, v := lastWithPrefix(tx.Bucket(supplyBucket), prefix)
+		if k != nil {
+			blockNumber, supply = binary.BigEndian.Uint64(k[len(prefix):]), bigValue(v)
+		}
+		return nil
+	})
+	return blockNumber, supply, supply != nil, err
+}
+
+// SaveToken stores the metadata of a token, replacing any previous metadata
+func (b *BoltDB) SaveToken(token Token) error {
+	token.UpdatedAt = time.Now().UTC()
+	value, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokensBucket).Put([]byte(token.ContractAddress), value)
+	})
+}
+
+// GetToken returns the stored metadata of a token, or false if it has not been saved
+func (b *BoltDB) GetToken(contractAddress string) (Token, bool, error) {
+	var token Token
+	var ok bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(tokensBucket).Get([]byte(contractAddress))
+		if v == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(v, &token)
+	})
+	return token, ok, err
+}
+
+// putLog stores a log under its key unless it is already stored, and advances the sync
+// checkpoint of its contract
+func putLog(tx *bolt.Tx, bucket []byte, contractAddress string, blockNumber uint64, key []byte, log any) error {
+	if err := advanceBoltCheckpoint(tx, contractAddress, blockNumber); err != nil {
+		return err
+	}
+	logs := tx.Bucket(bucket)
+	if logs.Get(key) != nil {
+		return nil
+	}
+	value, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+	return logs.Put(key, value)
+}
+
+// SaveContractEvent stores a generically decoded event and advances the sync checkpoint
+// of its contract, skipping events already stored
+func (b *BoltDB) SaveContractEvent(event ContractEvent) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		key := logKey(event.BlockNumber, event.LogIndex, event.BlockHash, event.TxHash)
+		return putLog(tx, contractEventsBucket, event.ContractAddress, event.BlockNumber, key, event)
+	})
+}
+
+// SaveNFTEvents stores the non-fungible token events of a log, moving tokens and
+// balances, and advances the sync checkpoint of their contract. Events already stored
+// are skipped.
+func (b *BoltDB) SaveNFTEvents(events []NFTEvent) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for i, event := range events {
+			key := nftKey(event, i)
+			exists := tx.Bucket(nftEventsBucket).Get(key) != nil
+			if err := putLog(tx, nftEventsBucket, event.ContractAddress, event.BlockNumber, key, event); err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+			if err := applyBoltNFTEvent(tx, event, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// applyBoltNFTEvent updates the owners and balances changed by a non-fungible token
+// event, or reverses the changes
+func applyBoltNFTEvent(tx *bolt.Tx, event NFTEvent, revert bool) error {
+	switch {
+	case event.EventType == "Transfer" && event.TokenID != nil && event.To != nil:
+		key := historyKey(compositeKey(event.ContractAddress, event.TokenID.String()), event.BlockNumber, event.LogIndex)
+		if revert {
+			return tx.Bucket(ownersBucket).Delete(key)
+		}
+		return tx.Bucket(ownersBucket).Put(key, []byte(*event.To))
+	case isMultiTokenTransfer(event.EventType) && event.TokenID != nil && event.Value != nil:
+		from, to := event.From, event.To
+		if revert {
+			from, to = to, from
+		}
+		balances := tx.Bucket(nftBalancesBucket)
+		tokenID := event.TokenID.String()
+		if from != nil && *from != zeroAddress {
+			if err := addBoltBalance(balances, compositeKey(event.ContractAddress, tokenID, *from), new(big.Int).Neg(event.Value)); err != nil {
+				return err
+			}
+		}
+		if to != nil && *to != zeroAddress {
+			return addBoltBalance(balances, compositeKey(event.ContractAddress, tokenID, *to), event.Value)
+		}
+	}
+	return nil
+}
+
+// GetTokenOwner returns the current owner of a non-fungible token, or false if the
+// token was never minted or has been burnt
+func (b *BoltDB) GetTokenOwner(contractAddress string, tokenID *big.Int) (string, bool, error) {
+	var owner string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		_, v := lastWithPrefix(tx.Bucket(ownersBucket), compositeKey(contractAddress, tokenID.String()))
+		owner = string(v)
+		return nil
+	})
+	if owner == zeroAddress {
+		owner = ""
+	}
+	return owner, owner != "", err
+}
+
+// GetNFTBalance returns the balance of a multi-token held by an address
+func (b *BoltDB) GetNFTBalance(contractAddress string, tokenID *big.Int, holder string) (*big.Int, error) {
+	balance := new(big.Int)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		balance = bigValue(tx.Bucket(nftBalancesBucket).Get(compositeKey(contractAddress, tokenID.String(), holder)))
+		return nil
+	})
+	return balance, err
+}
+
+// QuarantineLog stores a log that could not be decoded and advances the sync checkpoint
+// of its contract past it. Logs already stored are skipped.
+func (b *BoltDB) QuarantineLog(log QuarantinedLog) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		key := logKey(log.BlockNumber, log.LogIndex, log.BlockHash, log.TxHash)
+		return putLog(tx, quarantineBucket, log.ContractAddress, log.BlockNumber, key, log)
+	})
+}
+
+// GetCheckpoint returns the last processed block number of a contract, or false if
+// nothing has been stored for it yet
+func (b *BoltDB) GetCheckpoint(contractAddress string) (uint64, bool, error) {
+	var checkpoint uint64
+	var ok bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(checkpointsBucket).Get([]byte(contractAddress)); v != nil {
+			checkpoint, ok = binary.BigEndian.Uint64(v), true
+		}
+		return nil
+	})
+	return checkpoint, ok, err
+}
+
+// SaveBlock records the hash of a block that events were indexed from
+func (b *BoltDB) SaveBlock(blockNumber uint64, blockHash, parentHash string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(blocksBucket).Put(binary.BigEndian.AppendUint64(nil, blockNumber), compositeKey(blockHash, parentHash))
+	})
+}
+
+// GetBlockHash returns the stored hash of the given block, or false if it is not known
+func (b *BoltDB) GetBlockHash(blockNumber uint64) (string, bool, error) {
+	var blockHash string
+	var ok bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(blocksBucket).Get(binary.BigEndian.AppendUint64(nil, blockNumber)); v != nil {
+			hash, _, _ := bytes.Cut(v, []byte{0})
+			blockHash, ok = string(hash), true
+		}
+		return nil
+	})
+	return blockHash, ok, err
+}
+
+// LatestBlockBefore returns the highest stored block below the given block number and its hash
+func (b *BoltDB) LatestBlockBefore(blockNumber uint64) (uint64, string, bool, error) {
+	var latest uint64
+	var blockHash string
+	var ok bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(blocksBucket).Cursor()
+		k, v := c.Seek(binary.BigEndian.AppendUint64(nil, blockNumber))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		if k != nil {
+			hash, _, _ := bytes.Cut(v, []byte{0})
+			latest, blockHash, ok = binary.BigEndian.Uint64(k), string(hash), true
+		}
+		return nil
+	})
+	return latest, blockHash, ok, err
+}
+
+// deleteFrom deletes the entries of a bucket keyed by block number from the given block
+// onwards, passing each to fn first if it is not nil
+func deleteFrom(b *bolt.Bucket, fromBlock uint64, fn func(k, v []byte) error) error {
+	type entry struct{ k, v []byte }
+	var entries []entry
+	c := b.Cursor()
+	for k, v := c.Seek(binary.BigEndian.AppendUint64(nil, fromBlock)); k != nil; k, v = c.Next() {
+		entries = append(entries, entry{append([]byte(nil), k...), append([]byte(nil), v...)})
+	}
+
+	for _, e := range entries {
+		if fn != nil {
+			if err := fn(e.k, e.v); err != nil {
+				return err
+			}
+		}
+		if err := b.Delete(e.k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback deletes all events and blocks from the given block onwards, reverting the
+// state derived from them, and moves the sync checkpoints back before it
+func (b *BoltDB) Rollback(fromBlock uint64) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket(addressIndexBucket)
+		txIndex := tx.Bucket(txIndexBucket)
+		err := deleteFrom(tx.Bucket(eventsBucket), fromBlock, func(k, v []byte) error {
+			var event Event
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			for _, address := range eventAddresses(event) {
+				if err := index.Delete(append(compositeKey(address), k...)); err != nil {
+					return err
+				}
+			}
+			if err := txIndex.Delete(append(compositeKey(event.TxHash), k...)); err != nil {
+				return err
+			}
+			return revertBoltEvent(tx, event)
+		})
+		if err != nil {
+			return err
+		}
+		err = deleteFrom(tx.Bucket(nftEventsBucket), fromBlock, func(k, v []byte) error {
+			var event NFTEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			return applyBoltNFTEvent(tx, event, true)
+		})
+		if err != nil {
+			return err
+		}
+		for _, bucket := range [][]byte{contractEventsBucket, quarantineBucket, blocksBucket} {
+			if err := deleteFrom(tx.Bucket(bucket), fromBlock, nil); err != nil {
+				return err
+			}
+		}
+
+		checkpoints := tx.Bucket(checkpointsBucket)
+		var moved [][]byte
+		err = checkpoints.ForEach(func(k, v []byte) error {
+			if fromBlock == 0 || binary.BigEndian.Uint64(v) >= fromBlock {
+				moved = append(moved, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, contractAddress := range moved {
+			if fromBlock == 0 {
+				err = checkpoints.Delete(contractAddress)
+			} else {
+				err = checkpoints.Put(contractAddress, binary.BigEndian.AppendUint64(nil, fromBlock-1))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the database file
+func (b *BoltDB) Close() error {
+	return b.db.Close()
+}
+
+// scanEvents passes the ERC-20 events of the blocks from fromBlock up to and including
+// toBlock to fn in chain order
+func (b *BoltDB) scanEvents(fromBlock, toBlock uint64, fn func(Event) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(eventsBucket).Cursor()
+		for k, v := c.Seek(binary.BigEndian.AppendUint64(nil, fromBlock)); k != nil && binary.BigEndian.Uint64(k) <= toBlock; k, v = c.Next() {
+			var event Event
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// QueryEvents returns a page of the ERC-20 events matching a query in chain order. Queries
+// of an address or transaction scan its index, the others the block range of the query.
+func (b *BoltDB) QueryEvents(query EventQuery) (EventPage, error) {
+	after, err := parseCursor(query.Cursor)
+	if err != nil {
+		return EventPage{}, err
+	}
+
+	limit := query.limit()
+	var events []Event
+	err = b.db.View(func(tx *bolt.Tx) error {
+		stored := tx.Bucket(eventsBucket)
+		scanned, prefix := stored, []byte(nil)
+		switch {
+		case query.Address != "":
+			scanned, prefix = tx.Bucket(addressIndexBucket), compositeKey(query.Address)
+		case query.TxHash != "":
+			scanned, prefix = tx.Bucket(txIndexBucket), compositeKey(query.TxHash)
+		}
+
+		// Index keys end with the key of their event, so both are scanned from the
+		// first block of the query or the event after the cursor
+		start := binary.BigEndian.AppendUint64(append([]byte(nil), prefix...), query.FromBlock)
+		var skip []byte
+		if after != nil {
+			skip = append(append([]byte(nil), prefix...), logKey(after.blockNumber, after.logIndex, after.blockHash, after.txHash)...)
+			if bytes.Compare(skip, start) > 0 {
+				start = skip
+			}
+		}
+
+		c := scanned.Cursor()
+		for k, v := c.Seek(start); k != nil && bytes.HasPrefix(k, prefix) && len(events) <= limit; k, v = c.Next() {
+			key := k[len(prefix):]
+			if query.ToBlock > 0 && binary.BigEndian.Uint64(key) > query.ToBlock {
+				break
+			}
+			if bytes.Equal(k, skip) {
+				continue
+			}
+			if prefix != nil {
+				v = stored.Get(key)
+			}
+			var event Event
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			if query.matches(event) {
+				events = append(events, event)
+			}
+		}
+		return nil
+	})
+	return newEventPage(events, limit), err
+}
+
+// Snapshot writes a consistent copy of the database file to w without blocking writers,
+// and returns the number of bytes written
+func (b *BoltDB) Snapshot(w io.Writer) (int64, error) {
+	var n int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testBoltDB opens an empty bbolt database in a temporary directory
+func testBoltDB(t *testing.T) *BoltDB {
+	store, err := OpenBolt(filepath.Join(t.TempDir(), "indexer.db"))
+	if err != nil {
+		t.Fatalf("Failed to open the database: %v", err)
+	}
+	return store
+}
+
+func TestInitDB_Bolt(t *testing.T) {
+	connStr := "bolt://" + filepath.Join(t.TempDir(), "indexer.db")
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+
+	database := InitDB(connStr)
+	assert.IsType(t, &BoltDB{}, database)
+	assert.Nil(t, database.SaveEvent(testTransfer(contractAddress, 10)))
+	assert.Nil(t, database.Close())
+
+	// Reopening the file keeps the indexed data
+	database = InitDB(connStr)
+	defer database.Close()
+	checkpoint, ok, err := database.GetCheckpoint(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(10), checkpoint)
+	balance, err := database.GetBalance(contractAddress, "0x1234567890abcdef1234567890abcdef12345679")
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "1", balance.String())
+
+	_, err = Open(connStr)
+	assert.NotNil(t, err, "bbolt databases have no schema to migrate")
+}
+
+func TestBoltDB_Indexes(t *testing.T) {
+	store := testBoltDB(t)
+	defer store.Close()
+
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	alice := "0x1234567890abcdef1234567890abcdef12345678"
+	bob := "0x1234567890abcdef1234567890abcdef12345679"
+	carol := "0x1234567890abcdef1234567890abcdef1234567a"
+	event := func(blockNumber uint64, logIndex uint, eventType string, from, to, owner, spender *string) Event {
+		return Event{
+			ContractAddress: contractAddress,
+			BlockNumber:     blockNumber,
+			BlockHash:       testBlockHash(blockNumber),
+			TxHash:          testBlockHash(blockNumber + 1000),
+			LogIndex:        logIndex,
+			EventType:       eventType,
+			From:            from,
+			To:              to,
+			Owner:           owner,
+			Spender:         spender,
+			Value:           big.NewInt(10),
+		}
+	}
+
+	// Saved out of order, but stored in chain order
+	assert.Nil(t, store.SaveEvents([]Event{
+		event(11, 3, "Transfer", &bob, &carol, nil, nil),
+		event(11, 1, "Approval", nil, nil, &alice, &carol),
+		event(10, 0, "Transfer", &alice, &bob, nil, nil),
+		event(12, 0, "Transfer", &carol, &carol, nil, nil),
+	}))
+
+	page, err := store.QueryEvents(EventQuery{Address: carol})
+	assert.Nil(t, err, "Error should be nil")
+	if assert.Len(t, page.Events, 3) {
+		assert.Equal(t, uint(1), page.Events[0].LogIndex)
+		assert.Equal(t, uint(3), page.Events[1].LogIndex)
+		assert.Equal(t, uint64(12), page.Events[2].BlockNumber, "Self transfers should be listed once")
+	}
+	page, err = store.QueryEvents(EventQuery{Address: alice})
+	assert.Nil(t, err, "Error should be nil")
+	assert.Len(t, page.Events, 2)
+
+	page, err = store.QueryEvents(EventQuery{FromBlock: 11, ToBlock: 11})
+	assert.Nil(t, err, "Error should be nil")
+	if assert.Len(t, page.Events, 2) {
+		assert.Equal(t, "Approval", page.Events[0].EventType)
+		assert.Equal(t, "10", page.Events[1].Value.String())
+	}
+
+	// Rolled back events leave the address and transaction indexes
+	assert.Nil(t, store.Rollback(11))
+	page, err = store.QueryEvents(EventQuery{Address: carol})
+	assert.Nil(t, err, "Error should be nil")
+	assert.Empty(t, page.Events)
+	page, err = store.QueryEvents(EventQuery{TxHash: testBlockHash(1011)})
+	assert.Nil(t, err, "Error should be nil")
+	assert.Empty(t, page.Events)
+	page, err = store.QueryEvents(EventQuery{})
+	assert.Nil(t, err, "Error should be nil")
+	assert.Len(t, page.Events, 1)
+}
+
+func TestBoltDB_Snapshot(t *testing.T) {
+	store := testBoltDB(t)
+	defer store.Close()
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	assert.Nil(t, store.SaveEvent(testTransfer(contractAddress, 10)))
+
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	file, err := os.Create(path)
+	assert.Nil(t, err, "Error should be nil")
+	n, err := store.Snapshot(file)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Positive(t, n)
+	assert.Nil(t, file.Close())
+
+	// The snapshot is a database of its own, while the original stays writable
+	assert.Nil(t, store.SaveEvent(testTransfer(contractAddress, 11)))
+	snapshot, err := OpenBolt(path)
+	assert.Nil(t, err, "Error should be nil")
+	defer snapshot.Close()
+	checkpoint, _, err := snapshot.GetCheckpoint(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, uint64(10), checkpoint)
+	page, err := snapshot.QueryEvents(EventQuery{})
+	assert.Nil(t, err, "Error should be nil")
+	assert.Len(t, page.Events, 1)
+}
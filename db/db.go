@@ -2,8 +2,10 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 	"math/big"
+	"strings"
 	"time"
 
 	// import postgres driver
@@ -12,47 +14,174 @@ import (
 
 // Interface defines the methods that our database needs to implement
 type Interface interface {
-	SaveEvent(blockNumber uint64, txHash, eventType string, from, to, owner, spender *string, value *big.Int) error
+	SaveEvent(event Event) error
+	SaveEvents(events []Event) error
+	GetBalance(contractAddress, holder string) (*big.Int, error)
+	ReplayBalances(contractAddress string, toBlock uint64) (map[string]*big.Int, error)
+	GetAllowance(contractAddress, owner, spender string) (*big.Int, error)
+	GetUnlimitedApprovals(owner string) ([]Allowance, error)
+	GetTotalSupply(contractAddress string) (uint64, *big.Int, bool, error)
+	SaveToken(token Token) error
+	GetToken(contractAddress string) (Token, bool, error)
+	SaveContractEvent(event ContractEvent) error
+	SaveNFTEvents(events []NFTEvent) error
+	GetTokenOwner(contractAddress string, tokenID *big.Int) (string, bool, error)
+	GetNFTBalance(contractAddress string, tokenID *big.Int, holder string) (*big.Int, error)
+	QuarantineLog(log QuarantinedLog) error
+	GetCheckpoint(contractAddress string) (uint64, bool, error)
+	SaveBlock(blockNumber uint64, blockHash, parentHash string) error
+	GetBlockHash(blockNumber uint64) (string, bool, error)
+	LatestBlockBefore(blockNumber uint64) (uint64, string, bool, error)
+	Rollback(fromBlock uint64) error
+	QueryEvents(query EventQuery) (EventPage, error)
 	Close() error
 }
 
+// Event is an indexed contract event, identified by its block hash, transaction hash and
+// log index. Spender is set on a Transfer made through transferFrom to the caller whose
+// allowance it consumed.
+type Event struct {
+	ContractAddress string
+	BlockNumber     uint64
+	BlockHash       string
+	TxHash          string
+	LogIndex        uint
+	EventType       string
+	From            *string
+	To              *string
+	Owner           *string
+	Spender         *string
+	Value           *big.Int
+}
+
+// Token is the metadata of an indexed contract. Fields the contract does not implement are nil.
+type Token struct {
+	ContractAddress string
+	Name            *string
+	Symbol          *string
+	Decimals        *uint8
+	UpdatedAt       time.Time
+}
+
+// Allowance is the amount of a token a spender may still transfer on behalf of its owner
+type Allowance struct {
+	ContractAddress string
+	Owner           string
+	Spender         string
+	Amount          *big.Int
+	BlockNumber     uint64
+}
+
+// UnlimitedAllowance is the maximum uint256 amount, which tokens treat as an approval
+// that is never spent.
+var UnlimitedAllowance = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// ContractEvent is an event decoded generically from a contract ABI. Args holds the
+// JSON encoded arguments in declaration order.
+type ContractEvent struct {
+	ContractAddress string
+	BlockNumber     uint64
+	BlockHash       string
+	TxHash          string
+	LogIndex        uint
+	EventName       string
+	EventSignature  string
+	Args            string
+}
+
+// NFTEvent is an indexed event of an ERC-721 or ERC-1155 token. Operator and
+// ApprovedForAll are set for ApprovalForAll events, TokenID for the others. ERC-1155
+// transfers also carry an operator and a Value, and URI events the new URI of the token.
+type NFTEvent struct {
+	ContractAddress string
+	BlockNumber     uint64
+	BlockHash       string
+	TxHash          string
+	LogIndex        uint
+	EventType       string
+	From            *string
+	To              *string
+	Owner           *string
+	Approved        *string
+	Operator        *string
+	TokenID         *big.Int
+	Value           *big.Int
+	ApprovedForAll  *bool
+	URI             *string
+}
+
+// QuarantinedLog is a raw log that could not be decoded, kept so it can be inspected
+// and replayed. Topics holds the comma separated hex topics and Data the hex encoded data.
+type QuarantinedLog struct {
+	ContractAddress string
+	BlockNumber     uint64
+	BlockHash       string
+	TxHash          string
+	LogIndex        uint
+	Topics          string
+	Data            string
+	Reason          string
+}
+
+// zeroAddress is the sender of mints and the recipient of burns
+const zeroAddress = "0x0000000000000000000000000000000000000000"
+
 // DB is a struct that holds the database connection
 type DB struct {
-	conn *sql.DB
+	conn    *sql.DB
+	dialect dialect
 }
 
-// InitDB initializes the database connection with retry mechanism and returns the DB instance
+// InitDB initializes the database connection with retry mechanism, applies the pending
+// schema migrations and returns the DB instance. The memory:// connection string returns
+// an empty in-memory database instead, for dry runs, and bolt://<path> an embedded bbolt
+// database.
 func InitDB(connStr string) Interface {
-	conn, err := connectWithRetry(connStr, 10, 2*time.Second)
+	if connStr == memoryConnStr {
+		log.Println("Using an in-memory database, nothing will be persisted")
+		return NewMemoryDB()
+	}
+	if path, ok := strings.CutPrefix(connStr, boltScheme); ok {
+		store, err := OpenBolt(path)
+		if err != nil {
+			log.Fatalf("Could not open the database: %v", err)
+		}
+		log.Println("Database opened successfully")
+		return store
+	}
+
+	db, err := Open(connStr)
 	if err != nil {
 		log.Fatalf("Could not connect to the database: %v", err)
 	}
 
 	log.Println("Database connected successfully")
 
-	createTableQuery := `
-	CREATE TABLE IF NOT EXISTS erc20_events (
-		id SERIAL PRIMARY KEY,
-		block_number BIGINT NOT NULL,
-		tx_hash VARCHAR(66) NOT NULL,
-		event_type VARCHAR(50) NOT NULL,
-		from_address VARCHAR(42),
-		to_address VARCHAR(42),
-		owner_address VARCHAR(42),
-		spender_address VARCHAR(42),
-		value NUMERIC,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-	`
-
-	_, err = conn.Exec(createTableQuery)
-	if err != nil {
-		log.Fatalf("Failed to create table: %v", err)
+	if _, err := db.MigrateUp(); err != nil {
+		log.Fatalf("Failed to migrate the database: %v", err)
 	}
 
-	log.Println("Table erc20_events exists or created successfully")
+	log.Println("Database schema is up to date")
+
+	return db
+}
+
+// Open connects to the database without changing its schema. Connection strings of the
+// form sqlite://<path> open a SQLite database, any other SQL connection string a
+// Postgres database with retry mechanism.
+func Open(connStr string) (*DB, error) {
+	if connStr == memoryConnStr || strings.HasPrefix(connStr, boltScheme) {
+		return nil, fmt.Errorf("%s is not a SQL database", connStr)
+	}
+	if path, ok := strings.CutPrefix(connStr, sqliteScheme); ok {
+		return openSQLite(path)
+	}
 
-	return &DB{conn: conn}
+	conn, err := connectWithRetry(connStr, 10, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{conn: conn, dialect: postgres}, nil
 }
 
 // connectWithRetry attempts to connect to the database with retries.
@@ -79,20 +208,739 @@ func connectWithRetry(dsn string, retries int, delay time.Duration) (*sql.DB, er
 	return nil, err
 }
 
-// SaveEvent saves an indexed event to the database
-func (db *DB) SaveEvent(blockNumber uint64, txHash, eventType string, from, to, owner, spender *string, value *big.Int) error {
-	var valueStr *string
-	if value != nil {
-		v := value.String()
-		valueStr = &v
+// SaveEvent saves an indexed event to the database and advances the sync checkpoint
+// of its contract to its block in the same transaction
+func (db *DB) SaveEvent(event Event) error {
+	return db.SaveEvents([]Event{event})
+}
+
+// isTransfer reports whether an event type is an ERC-20 Transfer, including the mints
+// from and burns to the zero address
+func isTransfer(eventType string) bool {
+	return eventType == "Transfer" || eventType == "Mint" || eventType == "Burn"
+}
+
+// moveBalance moves an amount of a token from one holder to another. The zero address
+// is skipped, as it is the sender of mints and the recipient of burns.
+func moveBalance(tx *sql.Tx, contractAddress string, from, to *string, amount *big.Int) error {
+	if from != nil && *from != zeroAddress {
+		if err := addBalance(tx, contractAddress, *from, new(big.Int).Neg(amount)); err != nil {
+			return err
+		}
 	}
-	_, err := db.conn.Exec(`
-		INSERT INTO erc20_events (block_number, tx_hash, event_type, from_address, to_address, owner_address, spender_address, value)
+	if to != nil && *to != zeroAddress {
+		if err := addBalance(tx, contractAddress, *to, amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addBalance adds a signed amount to the balance of a holder, deleting empty balances
+func addBalance(tx *sql.Tx, contractAddress, holder string, amount *big.Int) error {
+	balance, err := scanBigInt(tx.QueryRow(`
+		SELECT balance FROM balances WHERE contract_address = $1 AND holder_address = $2
+	`, contractAddress, holder))
+	if err != nil {
+		return err
+	}
+
+	balance.Add(balance, amount)
+	if balance.Sign() == 0 {
+		_, err = tx.Exec(`DELETE FROM balances WHERE contract_address = $1 AND holder_address = $2`, contractAddress, holder)
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO balances (contract_address, holder_address, balance) VALUES ($1, $2, $3)
+		ON CONFLICT (contract_address, holder_address) DO UPDATE SET balance = excluded.balance
+	`, contractAddress, holder, balance.String())
+	return err
+}
+
+// GetBalance returns the balance of a token held by an address
+func (db *DB) GetBalance(contractAddress, holder string) (*big.Int, error) {
+	return scanBigInt(db.conn.QueryRow(`
+		SELECT balance FROM balances WHERE contract_address = $1 AND holder_address = $2
+	`, contractAddress, holder))
+}
+
+// ReplayBalances recomputes the balances of a token from its Transfer events up to and
+// including the given block, independently of the balances table
+func (db *DB) ReplayBalances(contractAddress string, toBlock uint64) (map[string]*big.Int, error) {
+	rows, err := db.conn.Query(`
+		SELECT from_address, to_address, value FROM erc20_events
+		WHERE contract_address = $1 AND event_type IN ('Transfer', 'Mint', 'Burn') AND block_number <= $2 AND value IS NOT NULL
+		ORDER BY id
+	`, contractAddress, toBlock)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := make(map[string]*big.Int)
+	add := func(holder *string, amount *big.Int) {
+		if holder == nil || *holder == zeroAddress {
+			return
+		}
+		balance, ok := balances[*holder]
+		if !ok {
+			balance = new(big.Int)
+			balances[*holder] = balance
+		}
+		balance.Add(balance, amount)
+	}
+	for rows.Next() {
+		var from, to *string
+		var value string
+		if err := rows.Scan(&from, &to, &value); err != nil {
+			return nil, err
+		}
+		amount, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid transfer value %q", value)
+		}
+		add(from, new(big.Int).Neg(amount))
+		add(to, amount)
+	}
+	return balances, rows.Err()
+}
+
+// setAllowance records the amount a spender may transfer on behalf of an owner
+func setAllowance(tx *sql.Tx, contractAddress, owner, spender string, amount *big.Int, blockNumber uint64, txHash string) error {
+	_, err := tx.Exec(`
+		INSERT INTO allowances (contract_address, owner_address, spender_address, amount, block_number, tx_hash)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (contract_address, owner_address, spender_address) DO UPDATE
+		SET amount = excluded.amount, block_number = excluded.block_number, tx_hash = excluded.tx_hash
+	`, contractAddress, owner, spender, amount.String(), blockNumber, txHash)
+	return err
+}
+
+// spendAllowance deducts a transferFrom from the allowance of its caller. Unlimited
+// allowances are not spent, and neither are allowances already updated by an Approval
+// emitted in the same transaction, as many tokens announce the reduced allowance.
+func spendAllowance(tx *sql.Tx, contractAddress, owner, spender string, amount *big.Int, blockNumber uint64, txHash string) error {
+	var current, approvalTx string
+	err := tx.QueryRow(`
+		SELECT amount, tx_hash FROM allowances WHERE contract_address = $1 AND owner_address = $2 AND spender_address = $3
+	`, contractAddress, owner, spender).Scan(&current, &approvalTx)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	allowance, ok := new(big.Int).SetString(current, 10)
+	if !ok {
+		return fmt.Errorf("invalid allowance %q", current)
+	}
+	if approvalTx == txHash || allowance.Cmp(UnlimitedAllowance) == 0 {
+		return nil
+	}
+
+	allowance.Sub(allowance, amount)
+	if allowance.Sign() < 0 {
+		allowance.SetInt64(0)
+	}
+	_, err = tx.Exec(`
+		UPDATE allowances SET amount = $1, block_number = $2
+		WHERE contract_address = $3 AND owner_address = $4 AND spender_address = $5
+	`, allowance.String(), blockNumber, contractAddress, owner, spender)
+	return err
+}
+
+// GetAllowance returns the amount a spender may still transfer on behalf of an owner
+func (db *DB) GetAllowance(contractAddress, owner, spender string) (*big.Int, error) {
+	return scanBigInt(db.conn.QueryRow(`
+		SELECT amount FROM allowances WHERE contract_address = $1 AND owner_address = $2 AND spender_address = $3
+	`, contractAddress, owner, spender))
+}
+
+// GetUnlimitedApprovals returns the unlimited allowances granted by an owner
+func (db *DB) GetUnlimitedApprovals(owner string) ([]Allowance, error) {
+	rows, err := db.conn.Query(`
+		SELECT contract_address, spender_address, block_number FROM allowances
+		WHERE owner_address = $1 AND amount = $2
+		ORDER BY contract_address, spender_address
+	`, owner, UnlimitedAllowance.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var allowances []Allowance
+	for rows.Next() {
+		a := Allowance{Owner: owner, Amount: new(big.Int).Set(UnlimitedAllowance)}
+		if err := rows.Scan(&a.ContractAddress, &a.Spender, &a.BlockNumber); err != nil {
+			return nil, err
+		}
+		allowances = append(allowances, a)
+	}
+	return allowances, rows.Err()
+}
+
+// SaveContractEvent saves a generically decoded event to the database and advances the
+// sync checkpoint of its contract to its block in the same transaction. Events already
+// stored are skipped.
+func (db *DB) SaveContractEvent(event ContractEvent) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO contract_events (contract_address, block_number, block_hash, tx_hash, log_index, event_name, event_signature, args)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (block_hash, tx_hash, log_index) DO NOTHING
+	`, event.ContractAddress, event.BlockNumber, event.BlockHash, event.TxHash, event.LogIndex, event.EventName, event.EventSignature, event.Args)
+	if err != nil {
+		return err
+	}
+
+	if err = advanceCheckpoint(tx, event.ContractAddress, event.BlockNumber); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SaveNFTEvents saves the non-fungible token events of a log to the database in a single
+// transaction with the sync checkpoint of their contract. An ERC-721 Transfer also moves
+// the token to its new owner, and burns it when sent to the zero address. ERC-1155
+// transfers move their value between the balances of the sender and the recipient.
+// Events already stored are skipped without changing owners or balances again.
+func (db *DB) SaveNFTEvents(events []NFTEvent) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, event := range events {
+		var tokenID *string
+		if event.TokenID != nil {
+			id := event.TokenID.String()
+			tokenID = &id
+		}
+		var value *string
+		if event.Value != nil {
+			v := event.Value.String()
+			value = &v
+		}
+
+		// The events of a TransferBatch share their log and are told apart by their position
+		res, err := tx.Exec(`
+			INSERT INTO nft_events (contract_address, block_number, block_hash, tx_hash, log_index, batch_index, event_type, from_address, to_address,
+				owner_address, approved_address, operator_address, token_id, value, approved_for_all, uri)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+			ON CONFLICT (block_hash, tx_hash, log_index, batch_index) DO NOTHING
+		`, event.ContractAddress, event.BlockNumber, event.BlockHash, event.TxHash, event.LogIndex, i, event.EventType, event.From, event.To,
+			event.Owner, event.Approved, event.Operator, tokenID, value, event.ApprovedForAll, event.URI)
+		if err != nil {
+			return err
+		}
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case inserted == 0:
+			// Replayed events have already moved their token
+		case event.EventType == "Transfer" && tokenID != nil && event.To != nil:
+			if *event.To == zeroAddress {
+				_, err = tx.Exec(`DELETE FROM nft_owners WHERE contract_address = $1 AND token_id = $2`, event.ContractAddress, *tokenID)
+			} else {
+				_, err = tx.Exec(`
+					INSERT INTO nft_owners (contract_address, token_id, owner_address, block_number) VALUES ($1, $2, $3, $4)
+					ON CONFLICT (contract_address, token_id) DO UPDATE SET owner_address = excluded.owner_address, block_number = excluded.block_number
+				`, event.ContractAddress, *tokenID, *event.To, event.BlockNumber)
+			}
+		case isMultiTokenTransfer(event.EventType) && event.TokenID != nil && event.Value != nil:
+			err = moveNFTBalance(tx, event.ContractAddress, event.TokenID, event.From, event.To, event.Value)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = advanceCheckpoint(tx, event.ContractAddress, event.BlockNumber); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// isMultiTokenTransfer reports whether an event type is an ERC-1155 transfer
+func isMultiTokenTransfer(eventType string) bool {
+	return eventType == "TransferSingle" || eventType == "TransferBatch"
+}
+
+// moveNFTBalance moves an amount of a multi-token from one holder to another. The zero
+// address is skipped, as it is the sender of mints and the recipient of burns.
+func moveNFTBalance(tx *sql.Tx, contractAddress string, tokenID *big.Int, from, to *string, amount *big.Int) error {
+	if from != nil && *from != zeroAddress {
+		if err := addNFTBalance(tx, contractAddress, tokenID, *from, new(big.Int).Neg(amount)); err != nil {
+			return err
+		}
+	}
+	if to != nil && *to != zeroAddress {
+		if err := addNFTBalance(tx, contractAddress, tokenID, *to, amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addNFTBalance adds a signed amount to the balance of a holder, deleting empty balances
+func addNFTBalance(tx *sql.Tx, contractAddress string, tokenID *big.Int, holder string, amount *big.Int) error {
+	balance, err := scanBigInt(tx.QueryRow(`
+		SELECT balance FROM nft_balances WHERE contract_address = $1 AND token_id = $2 AND holder_address = $3
+	`, contractAddress, tokenID.String(), holder))
+	if err != nil {
+		return err
+	}
+
+	balance.Add(balance, amount)
+	if balance.Sign() == 0 {
+		_, err = tx.Exec(`
+			DELETE FROM nft_balances WHERE contract_address = $1 AND token_id = $2 AND holder_address = $3
+		`, contractAddress, tokenID.String(), holder)
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO nft_balances (contract_address, token_id, holder_address, balance) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (contract_address, token_id, holder_address) DO UPDATE SET balance = excluded.balance
+	`, contractAddress, tokenID.String(), holder, balance.String())
+	return err
+}
+
+// scanBigInt reads a numeric column, returning zero when there is no row
+func scanBigInt(row *sql.Row) (*big.Int, error) {
+	var value string
+	err := row.Scan(&value)
+	if err == sql.ErrNoRows {
+		return new(big.Int), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid numeric value %q", value)
+	}
+	return n, nil
+}
+
+// GetTokenOwner returns the current owner of a non-fungible token, or false if the
+// token was never minted or has been burnt
+func (db *DB) GetTokenOwner(contractAddress string, tokenID *big.Int) (string, bool, error) {
+	var owner string
+	err := db.conn.QueryRow(`
+		SELECT owner_address FROM nft_owners WHERE contract_address = $1 AND token_id = $2
+	`, contractAddress, tokenID.String()).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return owner, true, nil
+}
+
+// GetNFTBalance returns the balance of a multi-token held by an address
+func (db *DB) GetNFTBalance(contractAddress string, tokenID *big.Int, holder string) (*big.Int, error) {
+	return scanBigInt(db.conn.QueryRow(`
+		SELECT balance FROM nft_balances WHERE contract_address = $1 AND token_id = $2 AND holder_address = $3
+	`, contractAddress, tokenID.String(), holder))
+}
+
+// QuarantineLog stores a log that could not be decoded and advances the sync checkpoint
+// of its contract past it in the same transaction. Logs already stored are skipped.
+func (db *DB) QuarantineLog(log QuarantinedLog) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO quarantined_logs (contract_address, block_number, block_hash, tx_hash, log_index, topics, data, reason)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-	`, blockNumber, txHash, eventType, from, to, owner, spender, valueStr)
+		ON CONFLICT (block_hash, tx_hash, log_index) DO NOTHING
+	`, log.ContractAddress, log.BlockNumber, log.BlockHash, log.TxHash, log.LogIndex, log.Topics, log.Data, log.Reason)
+	if err != nil {
+		return err
+	}
+
+	if err = advanceCheckpoint(tx, log.ContractAddress, log.BlockNumber); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// advanceCheckpoint moves the sync checkpoint of a contract forward to the given block
+func advanceCheckpoint(tx *sql.Tx, contractAddress string, blockNumber uint64) error {
+	_, err := tx.Exec(`
+		INSERT INTO sync_checkpoint (contract_address, block_number) VALUES ($1, $2)
+		ON CONFLICT (contract_address) DO UPDATE SET block_number = excluded.block_number, updated_at = CURRENT_TIMESTAMP
+		WHERE sync_checkpoint.block_number < excluded.block_number
+	`, contractAddress, blockNumber)
+	return err
+}
+
+// GetCheckpoint returns the last processed block number of a contract, or false if
+// nothing has been indexed for it yet
+func (db *DB) GetCheckpoint(contractAddress string) (uint64, bool, error) {
+	var blockNumber uint64
+	err := db.conn.QueryRow(`SELECT block_number FROM sync_checkpoint WHERE contract_address = $1`, contractAddress).Scan(&blockNumber)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return blockNumber, true, nil
+}
+
+// SaveBlock records the hash of a block that events were indexed from
+func (db *DB) SaveBlock(blockNumber uint64, blockHash, parentHash string) error {
+	var parent *string
+	if parentHash != "" {
+		parent = &parentHash
+	}
+	_, err := db.conn.Exec(`
+		INSERT INTO blocks (block_number, block_hash, parent_hash) VALUES ($1, $2, $3)
+		ON CONFLICT (block_number) DO UPDATE SET block_hash = excluded.block_hash, parent_hash = excluded.parent_hash
+	`, blockNumber, blockHash, parent)
 	return err
 }
 
+// GetBlockHash returns the stored hash of the given block, or false if it is not known
+func (db *DB) GetBlockHash(blockNumber uint64) (string, bool, error) {
+	var blockHash string
+	err := db.conn.QueryRow(`SELECT block_hash FROM blocks WHERE block_number = $1`, blockNumber).Scan(&blockHash)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return blockHash, true, nil
+}
+
+// LatestBlockBefore returns the highest stored block below the given block number and its hash
+func (db *DB) LatestBlockBefore(blockNumber uint64) (uint64, string, bool, error) {
+	var number uint64
+	var blockHash string
+	err := db.conn.QueryRow(`
+		SELECT block_number, block_hash FROM blocks WHERE block_number < $1
+		ORDER BY block_number DESC LIMIT 1
+	`, blockNumber).Scan(&number, &blockHash)
+	if err == sql.ErrNoRows {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, err
+	}
+	return number, blockHash, true, nil
+}
+
+// Rollback deletes all events and blocks from the given block onwards and moves the
+// sync checkpoint back before it, in a single transaction
+func (db *DB) Rollback(fromBlock uint64) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = revertBalances(tx, fromBlock); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM erc20_events WHERE block_number >= $1`, fromBlock); err != nil {
+		return err
+	}
+	if err = restoreAllowances(tx, fromBlock); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM contract_events WHERE block_number >= $1`, fromBlock); err != nil {
+		return err
+	}
+	if err = revertNFTBalances(tx, fromBlock); err != nil {
+		return err
+	}
+	if err = restoreTokenOwners(tx, fromBlock); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM nft_events WHERE block_number >= $1`, fromBlock); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM quarantined_logs WHERE block_number >= $1`, fromBlock); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM token_supply WHERE block_number >= $1`, fromBlock); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM blocks WHERE block_number >= $1`, fromBlock); err != nil {
+		return err
+	}
+
+	if fromBlock == 0 {
+		_, err = tx.Exec(`DELETE FROM sync_checkpoint`)
+	} else {
+		_, err = tx.Exec(`UPDATE sync_checkpoint SET block_number = $1, updated_at = CURRENT_TIMESTAMP WHERE block_number >= $1`, fromBlock-1)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// revertBalances reverses the token transfers from the given block onwards
+func revertBalances(tx *sql.Tx, fromBlock uint64) error {
+	rows, err := tx.Query(`
+		SELECT contract_address, from_address, to_address, value FROM erc20_events
+		WHERE block_number >= $1 AND event_type IN ('Transfer', 'Mint', 'Burn') AND value IS NOT NULL
+	`, fromBlock)
+	if err != nil {
+		return err
+	}
+
+	type transfer struct {
+		contractAddress string
+		from, to        *string
+		value           *big.Int
+	}
+	var transfers []transfer
+	for rows.Next() {
+		var t transfer
+		var value string
+		if err := rows.Scan(&t.contractAddress, &t.from, &t.to, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		t.value, _ = new(big.Int).SetString(value, 10)
+		transfers = append(transfers, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, t := range transfers {
+		if t.value == nil {
+			continue
+		}
+		if err := moveBalance(tx, t.contractAddress, t.to, t.from, t.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreAllowances recomputes the allowances changed from the given block onwards from
+// the remaining Approval and transferFrom events. It must run after those events are deleted.
+func restoreAllowances(tx *sql.Tx, fromBlock uint64) error {
+	rows, err := tx.Query(`
+		SELECT contract_address, owner_address, spender_address FROM allowances WHERE block_number >= $1
+	`, fromBlock)
+	if err != nil {
+		return err
+	}
+	var changed []Allowance
+	for rows.Next() {
+		var a Allowance
+		if err := rows.Scan(&a.ContractAddress, &a.Owner, &a.Spender); err != nil {
+			rows.Close()
+			return err
+		}
+		changed = append(changed, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, a := range changed {
+		_, err := tx.Exec(`
+			DELETE FROM allowances WHERE contract_address = $1 AND owner_address = $2 AND spender_address = $3
+		`, a.ContractAddress, a.Owner, a.Spender)
+		if err != nil {
+			return err
+		}
+
+		var id int64
+		var blockNumber uint64
+		var value, txHash string
+		err = tx.QueryRow(`
+			SELECT id, block_number, value, tx_hash FROM erc20_events
+			WHERE event_type = 'Approval' AND contract_address = $1 AND owner_address = $2 AND spender_address = $3
+			ORDER BY id DESC LIMIT 1
+		`, a.ContractAddress, a.Owner, a.Spender).Scan(&id, &blockNumber, &value, &txHash)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		amount, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return fmt.Errorf("invalid approval value %q", value)
+		}
+		if err = setAllowance(tx, a.ContractAddress, a.Owner, a.Spender, amount, blockNumber, txHash); err != nil {
+			return err
+		}
+
+		spends, err := tx.Query(`
+			SELECT block_number, value, tx_hash FROM erc20_events
+			WHERE event_type IN ('Transfer', 'Burn') AND contract_address = $1 AND from_address = $2 AND spender_address = $3 AND id > $4
+			ORDER BY id
+		`, a.ContractAddress, a.Owner, a.Spender, id)
+		if err != nil {
+			return err
+		}
+		type spend struct {
+			blockNumber uint64
+			value       *big.Int
+			txHash      string
+		}
+		var pending []spend
+		for spends.Next() {
+			var sp spend
+			var spent string
+			if err := spends.Scan(&sp.blockNumber, &spent, &sp.txHash); err != nil {
+				spends.Close()
+				return err
+			}
+			sp.value, _ = new(big.Int).SetString(spent, 10)
+			pending = append(pending, sp)
+		}
+		spends.Close()
+		if err := spends.Err(); err != nil {
+			return err
+		}
+		for _, sp := range pending {
+			if sp.value == nil {
+				continue
+			}
+			if err := spendAllowance(tx, a.ContractAddress, a.Owner, a.Spender, sp.value, sp.blockNumber, sp.txHash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// revertNFTBalances reverses the ERC-1155 transfers from the given block onwards
+func revertNFTBalances(tx *sql.Tx, fromBlock uint64) error {
+	rows, err := tx.Query(`
+		SELECT contract_address, token_id, from_address, to_address, value FROM nft_events
+		WHERE block_number >= $1 AND event_type IN ('TransferSingle', 'TransferBatch')
+	`, fromBlock)
+	if err != nil {
+		return err
+	}
+
+	type transfer struct {
+		contractAddress string
+		tokenID, value  *big.Int
+		from, to        *string
+	}
+	var transfers []transfer
+	for rows.Next() {
+		var t transfer
+		var tokenID, value string
+		if err := rows.Scan(&t.contractAddress, &tokenID, &t.from, &t.to, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		t.tokenID, _ = new(big.Int).SetString(tokenID, 10)
+		t.value, _ = new(big.Int).SetString(value, 10)
+		transfers = append(transfers, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, t := range transfers {
+		if t.tokenID == nil || t.value == nil {
+			continue
+		}
+		if err := moveNFTBalance(tx, t.contractAddress, t.tokenID, t.to, t.from, t.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreTokenOwners reverts the owners of the tokens transferred from the given block
+// onwards to the recipient of their last transfer before it. Only the tokens transferred
+// in the rolled back blocks are looked up, so it must run before their events are deleted.
+func restoreTokenOwners(tx *sql.Tx, fromBlock uint64) error {
+	rows, err := tx.Query(`
+		SELECT DISTINCT contract_address, token_id FROM nft_events
+		WHERE block_number >= $1 AND event_type = 'Transfer' AND token_id IS NOT NULL
+	`, fromBlock)
+	if err != nil {
+		return err
+	}
+	var tokens [][2]string
+	for rows.Next() {
+		var token [2]string
+		if err := rows.Scan(&token[0], &token[1]); err != nil {
+			rows.Close()
+			return err
+		}
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM nft_owners WHERE block_number >= $1`, fromBlock); err != nil {
+		return err
+	}
+	for start := 0; start < len(tokens); start += insertBatchSize {
+		end := start + insertBatchSize
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+
+		// SQLite numbers parameters in order of appearance, so the tokens come last
+		args := []interface{}{zeroAddress, fromBlock}
+		params := make([]string, 0, end-start)
+		for _, token := range tokens[start:end] {
+			args = append(args, token[0], token[1])
+			params = append(params, fmt.Sprintf("($%d, $%d)", len(args)-1, len(args)))
+		}
+		_, err := tx.Exec(`
+			INSERT INTO nft_owners (contract_address, token_id, owner_address, block_number)
+			SELECT e.contract_address, e.token_id, e.to_address, e.block_number FROM nft_events e
+			WHERE e.event_type = 'Transfer' AND e.to_address <> $1
+			AND e.id = (
+				SELECT MAX(l.id) FROM nft_events l
+				WHERE l.event_type = 'Transfer' AND l.contract_address = e.contract_address AND l.token_id = e.token_id
+				AND l.block_number < $2
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM nft_owners o WHERE o.contract_address = e.contract_address AND o.token_id = e.token_id
+			)
+			AND (e.contract_address, e.token_id) IN (`+strings.Join(params, ", ")+`)
+		`, args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Close closes the database connection
 func (db *DB) Close() error {
 	return db.conn.Close()
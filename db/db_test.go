@@ -1,67 +1,557 @@
 package db
 
 import (
-	"database/sql"
+	"fmt"
 	"log"
 	"math/big"
+	"path/filepath"
 	"testing"
 
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
 )
 
-// InitTestDB initializes an in-memory SQLite database for testing
-func InitTestDB() *sql.DB {
-	conn, err := sql.Open("sqlite3", ":memory:")
+// InitTestDB opens an in-memory SQLite database with the migrated schema for testing
+func InitTestDB() *DB {
+	db, err := Open("sqlite://:memory:")
 	if err != nil {
 		log.Fatalf("Failed to connect to the SQLite database: %v", err)
 	}
 
-	createTableQuery := `
-	CREATE TABLE IF NOT EXISTS erc20_events (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		block_number BIGINT NOT NULL,
-		tx_hash TEXT NOT NULL,
-		event_type TEXT NOT NULL,
-		from_address TEXT,
-		to_address TEXT,
-		owner_address TEXT,
-		spender_address TEXT,
-		value TEXT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-	`
-
-	_, err = conn.Exec(createTableQuery)
-	if err != nil {
-		log.Fatalf("Failed to create table: %v", err)
+	if _, err := db.MigrateUp(); err != nil {
+		log.Fatalf("Failed to migrate the SQLite database: %v", err)
 	}
 
-	return conn
+	return db
 }
 
 func TestInitDB(t *testing.T) {
-	conn := InitTestDB()
-	assert.NotNil(t, conn, "Database connection should not be nil")
+	db := InitTestDB()
+	assert.NotNil(t, db, "Database connection should not be nil")
+}
+
+func TestInitDB_SQLiteFile(t *testing.T) {
+	connStr := "sqlite://" + filepath.Join(t.TempDir(), "indexer.db")
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+
+	database := InitDB(connStr)
+	assert.Nil(t, database.SaveEvent(testTransfer(contractAddress, 10)))
+	assert.Nil(t, database.Close())
+
+	// Reopening the file keeps the indexed data and skips the applied migrations
+	database = InitDB(connStr)
+	defer database.Close()
+	checkpoint, ok, err := database.GetCheckpoint(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(10), checkpoint)
 }
 
 func TestSaveEvent(t *testing.T) {
-	conn := InitTestDB()
-	db := &DB{conn: conn}
+	db := InitTestDB()
 
-	blockNumber := uint64(123456)
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
 	txHash := "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef"
-	eventType := "Transfer"
 	from := "0x1234567890abcdef1234567890abcdef12345678"
 	to := "0x1234567890abcdef1234567890abcdef12345679"
-	value := big.NewInt(1000)
 
-	err := db.SaveEvent(blockNumber, txHash, eventType, &from, &to, nil, nil, value)
+	err := db.SaveEvent(Event{
+		ContractAddress: contractAddress,
+		BlockNumber:     123456,
+		BlockHash:       testBlockHash(123456),
+		TxHash:          txHash,
+		EventType:       "Transfer",
+		From:            &from,
+		To:              &to,
+		Value:           big.NewInt(1000),
+	})
 	assert.Nil(t, err, "Error should be nil")
 
 	// Add assertions to check if the event is correctly saved in the database
 	var count int
-	err = conn.QueryRow(`SELECT COUNT(*) FROM erc20_events WHERE tx_hash = ?`, txHash).Scan(&count)
+	err = db.conn.QueryRow(`SELECT COUNT(*) FROM erc20_events WHERE tx_hash = ? AND contract_address = ?`, txHash, contractAddress).Scan(&count)
 	assert.Nil(t, err, "Error should be nil")
 	assert.Equal(t, 1, count, "Event should be saved in the database")
 }
+
+func TestSaveEvent_Replayed(t *testing.T) {
+	db := InitTestDB()
+
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	first := testTransfer(contractAddress, 10)
+	// An identical Transfer later in the same transaction is a different log
+	second := first
+	second.LogIndex = 1
+
+	assert.Nil(t, db.SaveEvents([]Event{first, second}))
+	// An overlapping backfill replays both logs, and a batch may hold a log twice
+	assert.Nil(t, db.SaveEvents([]Event{first, second, second}))
+	assert.Nil(t, db.SaveEvent(first))
+
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM erc20_events`).Scan(&count)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, 2, count, "Replayed logs should not be stored again")
+
+	balance, err := db.GetBalance(contractAddress, *first.To)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "2", balance.String(), "Replayed logs should not change balances again")
+
+	// The same log in a reorganized block is stored separately
+	reorged := first
+	reorged.BlockHash = "0xabcdef"
+	assert.Nil(t, db.SaveEvent(reorged))
+	err = db.conn.QueryRow(`SELECT COUNT(*) FROM erc20_events`).Scan(&count)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, 3, count)
+
+	nft := testNFTTransfer(contractAddress, 11, zeroAddress, *first.To, 1)
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{nft}))
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{testNFTTransfer(contractAddress, 12, *first.To, *first.From, 1)}))
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{nft}))
+	owner, _, err := db.GetTokenOwner(contractAddress, big.NewInt(1))
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, *first.From, owner, "Replayed transfers should not move tokens back")
+}
+
+func TestSaveContractEvent(t *testing.T) {
+	db := InitTestDB()
+
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	err := db.SaveContractEvent(ContractEvent{
+		ContractAddress: contractAddress,
+		BlockNumber:     300,
+		BlockHash:       testBlockHash(300),
+		TxHash:          "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+		LogIndex:        4,
+		EventName:       "Paused",
+		EventSignature:  "Paused(address)",
+		Args:            `[{"name":"account","type":"address","indexed":false,"value":"0x1234567890abcdef1234567890abcdef12345678"}]`,
+	})
+	assert.Nil(t, err, "Error should be nil")
+
+	var name, args string
+	err = db.conn.QueryRow(`SELECT event_name, args FROM contract_events WHERE contract_address = ?`, contractAddress).Scan(&name, &args)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "Paused", name)
+	assert.Contains(t, args, "account")
+
+	checkpoint, _, err := db.GetCheckpoint(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, uint64(300), checkpoint, "Checkpoint should advance with generic events")
+}
+
+// testNFTTransfer creates an ERC-721 Transfer event
+func testNFTTransfer(contractAddress string, blockNumber uint64, from, to string, tokenID int64) NFTEvent {
+	return NFTEvent{
+		ContractAddress: contractAddress,
+		BlockNumber:     blockNumber,
+		BlockHash:       testBlockHash(blockNumber),
+		TxHash:          "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+		EventType:       "Transfer",
+		From:            &from,
+		To:              &to,
+		TokenID:         big.NewInt(tokenID),
+	}
+}
+
+func TestSaveNFTEvents(t *testing.T) {
+	db := InitTestDB()
+
+	contractAddress := "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D"
+	alice := "0x1234567890abcdef1234567890abcdef12345678"
+	bob := "0x1234567890abcdef1234567890abcdef12345679"
+
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{testNFTTransfer(contractAddress, 10, zeroAddress, alice, 1)}))
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{testNFTTransfer(contractAddress, 11, zeroAddress, alice, 2)}))
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{testNFTTransfer(contractAddress, 12, alice, bob, 1)}))
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{testNFTTransfer(contractAddress, 13, alice, zeroAddress, 2)}))
+
+	approved := true
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{{
+		ContractAddress: contractAddress,
+		BlockNumber:     14,
+		BlockHash:       testBlockHash(14),
+		TxHash:          "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+		EventType:       "ApprovalForAll",
+		Owner:           &bob,
+		Operator:        &alice,
+		ApprovedForAll:  &approved,
+	}}))
+
+	owner, ok, err := db.GetTokenOwner(contractAddress, big.NewInt(1))
+	assert.Nil(t, err, "Error should be nil")
+	assert.True(t, ok)
+	assert.Equal(t, bob, owner, "Transfers should move the token to the recipient")
+
+	_, ok, err = db.GetTokenOwner(contractAddress, big.NewInt(2))
+	assert.Nil(t, err, "Error should be nil")
+	assert.False(t, ok, "Burnt tokens should have no owner")
+
+	var count int
+	err = db.conn.QueryRow(`SELECT COUNT(*) FROM nft_events WHERE contract_address = ?`, contractAddress).Scan(&count)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, 5, count)
+
+	checkpoint, _, err := db.GetCheckpoint(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, uint64(14), checkpoint)
+
+	// Rolling back the transfer and the burn restores the previous owners
+	assert.Nil(t, db.Rollback(12))
+	owner, _, err = db.GetTokenOwner(contractAddress, big.NewInt(1))
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, alice, owner)
+	owner, ok, err = db.GetTokenOwner(contractAddress, big.NewInt(2))
+	assert.Nil(t, err, "Error should be nil")
+	assert.True(t, ok, "Rolled back burns should restore the token")
+	assert.Equal(t, alice, owner)
+}
+
+func TestRollback_TokenOwners(t *testing.T) {
+	db := InitTestDB()
+
+	apes := "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D"
+	punks := "0xb47e3cd837dDF8e4c57F05d70Ab865de6e193BBB"
+	cats := "0x06012c8cf97BEaD5deAe237070F9587f8E7A266d"
+	alice := "0x1234567890abcdef1234567890abcdef12345678"
+	bob := "0x1234567890abcdef1234567890abcdef12345679"
+
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{testNFTTransfer(apes, 10, zeroAddress, alice, 1)}))
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{testNFTTransfer(punks, 11, zeroAddress, alice, 1)}))
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{testNFTTransfer(apes, 12, zeroAddress, bob, 2)}))
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{testNFTTransfer(apes, 13, alice, bob, 1)}))
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{testNFTTransfer(punks, 14, alice, zeroAddress, 1)}))
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{testNFTTransfer(cats, 9, zeroAddress, alice, 1)}))
+	// Only the owners of the (contract, token) pairs transferred in the rolled back blocks
+	// are restored, not those of every contract with the same token ID
+	_, err := db.conn.Exec(`DELETE FROM nft_owners WHERE contract_address = $1`, cats)
+	assert.Nil(t, err, "Error should be nil")
+
+	assert.Nil(t, db.Rollback(13))
+
+	owners := map[string]string{apes: alice, punks: alice}
+	for contractAddress, expected := range owners {
+		owner, ok, err := db.GetTokenOwner(contractAddress, big.NewInt(1))
+		assert.Nil(t, err, "Error should be nil")
+		assert.True(t, ok)
+		assert.Equal(t, expected, owner, "Tokens transferred in rolled back blocks should return to their previous owner")
+	}
+	owner, _, err := db.GetTokenOwner(apes, big.NewInt(2))
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, bob, owner, "Tokens untouched by the rolled back blocks should keep their owner")
+
+	var count int
+	err = db.conn.QueryRow(`SELECT COUNT(*) FROM nft_owners`).Scan(&count)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, 3, count)
+}
+
+// testMultiTokenTransfer creates an ERC-1155 transfer of a single ID
+func testMultiTokenTransfer(contractAddress string, blockNumber uint64, from, to string, tokenID, value int64) NFTEvent {
+	event := testNFTTransfer(contractAddress, blockNumber, from, to, tokenID)
+	event.EventType = "TransferBatch"
+	event.Value = big.NewInt(value)
+	return event
+}
+
+func TestNFTBalances(t *testing.T) {
+	db := InitTestDB()
+
+	contractAddress := "0x76BE3b62873462d2142405439777e971754E8E77"
+	alice := "0x1234567890abcdef1234567890abcdef12345678"
+	bob := "0x1234567890abcdef1234567890abcdef12345679"
+
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{
+		testMultiTokenTransfer(contractAddress, 10, zeroAddress, alice, 1, 100),
+		testMultiTokenTransfer(contractAddress, 10, zeroAddress, alice, 2, 5),
+	}))
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{testMultiTokenTransfer(contractAddress, 11, alice, bob, 1, 40)}))
+	assert.Nil(t, db.SaveNFTEvents([]NFTEvent{testMultiTokenTransfer(contractAddress, 12, alice, zeroAddress, 2, 5)}))
+
+	balance, err := db.GetNFTBalance(contractAddress, big.NewInt(1), alice)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "60", balance.String())
+	balance, err = db.GetNFTBalance(contractAddress, big.NewInt(1), bob)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "40", balance.String())
+	balance, err = db.GetNFTBalance(contractAddress, big.NewInt(2), alice)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "0", balance.String(), "Burns should reduce the balance")
+
+	var count int
+	err = db.conn.QueryRow(`SELECT COUNT(*) FROM nft_balances`).Scan(&count)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, 2, count, "Empty balances should be deleted")
+
+	// Rolling back reverses the transfers of the reorganized blocks
+	assert.Nil(t, db.Rollback(11))
+	balance, err = db.GetNFTBalance(contractAddress, big.NewInt(1), alice)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "100", balance.String())
+	balance, err = db.GetNFTBalance(contractAddress, big.NewInt(2), alice)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "5", balance.String())
+	balance, err = db.GetNFTBalance(contractAddress, big.NewInt(1), bob)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "0", balance.String())
+}
+
+func TestQuarantineLog(t *testing.T) {
+	db := InitTestDB()
+
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	err := db.QuarantineLog(QuarantinedLog{
+		ContractAddress: contractAddress,
+		BlockNumber:     400,
+		BlockHash:       "0xabcdef",
+		TxHash:          "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+		LogIndex:        2,
+		Topics:          "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+		Data:            "0x",
+		Reason:          "malformed Transfer log: expected 3 topics, got 1",
+	})
+	assert.Nil(t, err, "Error should be nil")
+
+	var topics, reason string
+	err = db.conn.QueryRow(`SELECT topics, reason FROM quarantined_logs WHERE contract_address = ?`, contractAddress).Scan(&topics, &reason)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef", topics)
+	assert.Contains(t, reason, "expected 3 topics")
+
+	checkpoint, _, err := db.GetCheckpoint(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, uint64(400), checkpoint, "Checkpoint should advance past quarantined logs")
+
+	assert.Nil(t, db.Rollback(400))
+	var count int
+	err = db.conn.QueryRow(`SELECT COUNT(*) FROM quarantined_logs`).Scan(&count)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, 0, count, "Quarantined logs of rolled back blocks should be deleted")
+}
+
+func TestCheckpoint(t *testing.T) {
+	db := InitTestDB()
+
+	usdc := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	dai := "0x6B175474E89094C44Da98b954EedeAC495271d0F"
+
+	_, ok, err := db.GetCheckpoint(usdc)
+	assert.Nil(t, err, "Error should be nil")
+	assert.False(t, ok, "Checkpoint should not exist before any event is saved")
+
+	err = db.SaveEvent(testTransfer(usdc, 200))
+	assert.Nil(t, err, "Error should be nil")
+
+	checkpoint, ok, err := db.GetCheckpoint(usdc)
+	assert.Nil(t, err, "Error should be nil")
+	assert.True(t, ok, "Checkpoint should exist after an event is saved")
+	assert.Equal(t, uint64(200), checkpoint)
+
+	// An older event must not move the checkpoint backwards
+	err = db.SaveEvent(testTransfer(usdc, 150))
+	assert.Nil(t, err, "Error should be nil")
+
+	checkpoint, _, err = db.GetCheckpoint(usdc)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, uint64(200), checkpoint)
+
+	// Checkpoints are tracked per contract
+	_, ok, err = db.GetCheckpoint(dai)
+	assert.Nil(t, err, "Error should be nil")
+	assert.False(t, ok, "Other contracts should have no checkpoint")
+}
+
+// testBlockHash returns a distinct hash for every block number
+func testBlockHash(blockNumber uint64) string {
+	return fmt.Sprintf("0x%064x", blockNumber)
+}
+
+// testTransfer creates a Transfer event of the given contract at the given block
+func testTransfer(contractAddress string, blockNumber uint64) Event {
+	from := "0x1234567890abcdef1234567890abcdef12345678"
+	to := "0x1234567890abcdef1234567890abcdef12345679"
+	return Event{
+		ContractAddress: contractAddress,
+		BlockNumber:     blockNumber,
+		BlockHash:       testBlockHash(blockNumber),
+		TxHash:          "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+		EventType:       "Transfer",
+		From:            &from,
+		To:              &to,
+		Value:           big.NewInt(1),
+	}
+}
+
+func TestBalances(t *testing.T) {
+	db := InitTestDB()
+
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	alice := "0x1234567890abcdef1234567890abcdef12345678"
+	bob := "0x1234567890abcdef1234567890abcdef12345679"
+	transfer := func(blockNumber uint64, from, to string, value int64) Event {
+		return Event{
+			ContractAddress: contractAddress,
+			BlockNumber:     blockNumber,
+			BlockHash:       testBlockHash(blockNumber),
+			TxHash:          "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+			EventType:       "Transfer",
+			From:            &from,
+			To:              &to,
+			Value:           big.NewInt(value),
+		}
+	}
+
+	assert.Nil(t, db.SaveEvent(transfer(10, zeroAddress, alice, 100)))
+	assert.Nil(t, db.SaveEvent(transfer(11, alice, bob, 30)))
+	assert.Nil(t, db.SaveEvent(transfer(12, bob, zeroAddress, 30)))
+
+	balance, err := db.GetBalance(contractAddress, alice)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "70", balance.String())
+	balance, err = db.GetBalance(contractAddress, bob)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "0", balance.String(), "Burns should reduce the balance")
+
+	var count int
+	err = db.conn.QueryRow(`SELECT COUNT(*) FROM balances`).Scan(&count)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, 1, count, "Empty balances and the zero address should not be stored")
+
+	replayed, err := db.ReplayBalances(contractAddress, 11)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "70", replayed[alice].String())
+	assert.Equal(t, "30", replayed[bob].String(), "Transfers after the block should not be replayed")
+
+	// Rolling back the burn restores the balance of the sender
+	assert.Nil(t, db.Rollback(12))
+	balance, err = db.GetBalance(contractAddress, bob)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "30", balance.String())
+	balance, err = db.GetBalance(contractAddress, alice)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "70", balance.String())
+}
+
+func TestAllowances(t *testing.T) {
+	db := InitTestDB()
+
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	otherContract := "0xdAC17F958D2ee523a2206206994597C13D831ec7"
+	owner := "0x1234567890abcdef1234567890abcdef12345678"
+	spender := "0x1234567890abcdef1234567890abcdef12345679"
+	recipient := "0x1234567890abcdef1234567890abcdef1234567a"
+	var logIndex uint
+	approve := func(contract string, blockNumber uint64, txHash string, value *big.Int) Event {
+		logIndex++
+		return Event{
+			ContractAddress: contract,
+			BlockNumber:     blockNumber,
+			BlockHash:       testBlockHash(blockNumber),
+			TxHash:          txHash,
+			LogIndex:        logIndex,
+			EventType:       "Approval",
+			Owner:           &owner,
+			Spender:         &spender,
+			Value:           value,
+		}
+	}
+	transferFrom := func(blockNumber uint64, txHash string, value int64) Event {
+		logIndex++
+		return Event{
+			ContractAddress: contractAddress,
+			BlockNumber:     blockNumber,
+			BlockHash:       testBlockHash(blockNumber),
+			TxHash:          txHash,
+			LogIndex:        logIndex,
+			EventType:       "Transfer",
+			From:            &owner,
+			To:              &recipient,
+			Spender:         &spender,
+			Value:           big.NewInt(value),
+		}
+	}
+
+	assert.Nil(t, db.SaveEvent(approve(contractAddress, 10, "0x01", big.NewInt(100))))
+	assert.Nil(t, db.SaveEvent(transferFrom(11, "0x02", 30)))
+	// Tokens announcing the reduced allowance emit an Approval before the Transfer
+	assert.Nil(t, db.SaveEvent(approve(contractAddress, 12, "0x03", big.NewInt(50))))
+	assert.Nil(t, db.SaveEvent(transferFrom(12, "0x03", 20)))
+
+	allowance, err := db.GetAllowance(contractAddress, owner, spender)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "50", allowance.String(), "transferFrom should consume the allowance once")
+
+	assert.Nil(t, db.SaveEvent(transferFrom(13, "0x04", 15)))
+	allowance, err = db.GetAllowance(contractAddress, owner, spender)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "35", allowance.String())
+
+	// Rolling back re-applies the remaining approvals and transfers
+	assert.Nil(t, db.Rollback(12))
+	allowance, err = db.GetAllowance(contractAddress, owner, spender)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "70", allowance.String())
+
+	assert.Nil(t, db.SaveEvent(approve(otherContract, 14, "0x05", UnlimitedAllowance)))
+	assert.Nil(t, db.SaveEvent(approve(contractAddress, 15, "0x06", UnlimitedAllowance)))
+	assert.Nil(t, db.SaveEvent(transferFrom(16, "0x07", 15)))
+	approvals, err := db.GetUnlimitedApprovals(owner)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Len(t, approvals, 2, "Unlimited allowances should not be spent")
+	assert.Equal(t, contractAddress, approvals[0].ContractAddress)
+	assert.Equal(t, spender, approvals[0].Spender)
+}
+
+func TestBlocks(t *testing.T) {
+	db := InitTestDB()
+
+	_, _, ok, err := db.LatestBlockBefore(100)
+	assert.Nil(t, err, "Error should be nil")
+	assert.False(t, ok, "No block should be stored yet")
+
+	assert.Nil(t, db.SaveBlock(10, "0xa10", "0xa09"))
+	assert.Nil(t, db.SaveBlock(12, "0xa12", "0xa11"))
+
+	blockHash, ok, err := db.GetBlockHash(12)
+	assert.Nil(t, err, "Error should be nil")
+	assert.True(t, ok)
+	assert.Equal(t, "0xa12", blockHash)
+
+	// Saving the same height again replaces the hash
+	assert.Nil(t, db.SaveBlock(12, "0xb12", "0xa11"))
+	blockHash, _, err = db.GetBlockHash(12)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "0xb12", blockHash)
+
+	number, blockHash, ok, err := db.LatestBlockBefore(12)
+	assert.Nil(t, err, "Error should be nil")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(10), number)
+	assert.Equal(t, "0xa10", blockHash)
+}
+
+func TestRollback(t *testing.T) {
+	db := InitTestDB()
+
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	for _, blockNumber := range []uint64{10, 11, 12} {
+		assert.Nil(t, db.SaveBlock(blockNumber, "0xhash", ""))
+		assert.Nil(t, db.SaveEvent(testTransfer(contractAddress, blockNumber)))
+	}
+
+	err := db.Rollback(11)
+	assert.Nil(t, err, "Error should be nil")
+
+	var count int
+	err = db.conn.QueryRow(`SELECT COUNT(*) FROM erc20_events`).Scan(&count)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, 1, count, "Events from the rolled back blocks should be deleted")
+
+	_, ok, err := db.GetBlockHash(11)
+	assert.Nil(t, err, "Error should be nil")
+	assert.False(t, ok, "Rolled back blocks should be deleted")
+
+	checkpoint, _, err := db.GetCheckpoint(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, uint64(10), checkpoint, "Checkpoint should move before the rolled back blocks")
+}
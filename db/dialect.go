@@ -0,0 +1,32 @@
+package db
+
+// dialect describes how the SQL of a supported database differs from Postgres
+type dialect struct {
+	// Name is the database/sql driver name
+	Name string
+	// Postgres is set for Postgres, which supports ADD COLUMN IF NOT EXISTS
+	Postgres bool
+	// AutoIncrement is the type of an auto-incrementing integer primary key
+	AutoIncrement string
+	// Numeric is the type of arbitrary precision integers
+	Numeric string
+	// JSON is the type of JSON documents
+	JSON string
+}
+
+var (
+	postgres = dialect{
+		Name:          "postgres",
+		Postgres:      true,
+		AutoIncrement: "SERIAL PRIMARY KEY",
+		Numeric:       "NUMERIC",
+		JSON:          "JSONB",
+	}
+	// SQLite stores big integers as text, as its NUMERIC affinity rounds them to floats
+	sqlite = dialect{
+		Name:          "sqlite3",
+		AutoIncrement: "INTEGER PRIMARY KEY AUTOINCREMENT",
+		Numeric:       "TEXT",
+		JSON:          "TEXT",
+	}
+)
@@ -0,0 +1,583 @@
+package db
+
+import (
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryConnStr is the connection string of an in-memory database
+const memoryConnStr = "memory://"
+
+// MemoryDB is an in-memory Interface for unit tests, dry runs and replaying fixtures. It
+// applies events like DB does, including balances, allowances, supplies, token owners,
+// replayed logs and rollbacks, and can list everything it stored.
+type MemoryDB struct {
+	mu sync.RWMutex
+
+	events         []Event
+	contractEvents []ContractEvent
+	nftEvents      []memoryNFTEvent
+	quarantined    []QuarantinedLog
+	tokens         map[string]Token
+	checkpoints    map[string]uint64
+	blocks         map[uint64][2]string
+
+	// Keys of the stored logs, so replayed logs are skipped
+	eventKeys      map[eventKey]bool
+	contractKeys   map[eventKey]bool
+	nftKeys        map[nftEventKey]bool
+	quarantineKeys map[eventKey]bool
+
+	// State derived from the stored events, rebuilt on rollback
+	balances    map[[2]string]*big.Int
+	allowances  map[[3]string]memoryAllowance
+	supplies    map[string]map[uint64]*big.Int
+	owners      map[[2]string]string
+	nftBalances map[[3]string]*big.Int
+}
+
+// nftEventKey identifies one of the events stored for the log of a non-fungible token
+type nftEventKey struct {
+	eventKey
+	batchIndex int
+}
+
+// memoryNFTEvent is a stored non-fungible token event along with its key
+type memoryNFTEvent struct {
+	NFTEvent
+	key nftEventKey
+}
+
+// memoryAllowance is an allowance along with the transaction of its last Approval
+type memoryAllowance struct {
+	amount      *big.Int
+	blockNumber uint64
+	txHash      string
+}
+
+// NewMemoryDB returns an empty in-memory database
+func NewMemoryDB() *MemoryDB {
+	m := &MemoryDB{
+		tokens:         make(map[string]Token),
+		checkpoints:    make(map[string]uint64),
+		blocks:         make(map[uint64][2]string),
+		eventKeys:      make(map[eventKey]bool),
+		contractKeys:   make(map[eventKey]bool),
+		nftKeys:        make(map[nftEventKey]bool),
+		quarantineKeys: make(map[eventKey]bool),
+	}
+	m.resetDerived()
+	return m
+}
+
+// resetDerived clears the state derived from the stored events
+func (m *MemoryDB) resetDerived() {
+	m.balances = make(map[[2]string]*big.Int)
+	m.allowances = make(map[[3]string]memoryAllowance)
+	m.supplies = make(map[string]map[uint64]*big.Int)
+	m.owners = make(map[[2]string]string)
+	m.nftBalances = make(map[[3]string]*big.Int)
+}
+
+// advanceCheckpoint moves the sync checkpoint of a contract forward to the given block
+func (m *MemoryDB) advanceCheckpoint(contractAddress string, blockNumber uint64) {
+	if checkpoint, ok := m.checkpoints[contractAddress]; !ok || checkpoint < blockNumber {
+		m.checkpoints[contractAddress] = blockNumber
+	}
+}
+
+// SaveEvent stores an event and advances the sync checkpoint of its contract
+func (m *MemoryDB) SaveEvent(event Event) error {
+	return m.SaveEvents([]Event{event})
+}
+
+// SaveEvents stores events and advances the sync checkpoints of their contracts,
+// skipping events already stored
+func (m *MemoryDB) SaveEvents(events []Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, event := range events {
+		m.advanceCheckpoint(event.ContractAddress, event.BlockNumber)
+		key := eventKey{event.BlockHash, event.TxHash, event.LogIndex}
+		if m.eventKeys[key] {
+			continue
+		}
+		m.eventKeys[key] = true
+		m.events = append(m.events, event)
+		m.applyEvent(event)
+	}
+	return nil
+}
+
+// applyEvent updates the balances, supplies and allowances changed by an event
+func (m *MemoryDB) applyEvent(event Event) {
+	switch {
+	case isTransfer(event.EventType) && event.Value != nil:
+		m.moveBalance(event.ContractAddress, event.From, event.To, event.Value)
+		if event.EventType == "Mint" || event.EventType == "Burn" {
+			m.updateSupply(event.ContractAddress, event.BlockNumber, event.EventType, event.Value)
+		}
+		if event.From != nil && event.Spender != nil {
+			m.spendAllowance(event.ContractAddress, *event.From, *event.Spender, event.Value, event.BlockNumber, event.TxHash)
+		}
+	case event.EventType == "Approval" && event.Value != nil && event.Owner != nil && event.Spender != nil:
+		m.allowances[[3]string{event.ContractAddress, *event.Owner, *event.Spender}] = memoryAllowance{
+			amount:      new(big.Int).Set(event.Value),
+			blockNumber: event.BlockNumber,
+			txHash:      event.TxHash,
+		}
+	}
+}
+
+// moveBalance moves an amount of a token from one holder to another, skipping the zero address
+func (m *MemoryDB) moveBalance(contractAddress string, from, to *string, amount *big.Int) {
+	if from != nil && *from != zeroAddress {
+		addTo(m.balances, [2]string{contractAddress, *from}, new(big.Int).Neg(amount))
+	}
+	if to != nil && *to != zeroAddress {
+		addTo(m.balances, [2]string{contractAddress, *to}, amount)
+	}
+}
+
+// addTo adds a signed amount to a balance, deleting empty balances
+func addTo[K comparable](balances map[K]*big.Int, key K, amount *big.Int) {
+	balance, ok := balances[key]
+	if !ok {
+		balance = new(big.Int)
+	}
+	balance = new(big.Int).Add(balance, amount)
+	if balance.Sign() == 0 {
+		delete(balances, key)
+		return
+	}
+	balances[key] = balance
+}
+
+// updateSupply records the total supply of a token after a mint or burn in the given block
+func (m *MemoryDB) updateSupply(contractAddress string, blockNumber uint64, eventType string, amount *big.Int) {
+	supplies, ok := m.supplies[contractAddress]
+	if !ok {
+		supplies = make(map[uint64]*big.Int)
+		m.supplies[contractAddress] = supplies
+	}
+	supply := new(big.Int)
+	if latest, ok := latestSupply(supplies); ok {
+		supply.Set(supplies[latest])
+	}
+	if eventType == "Mint" {
+		supply.Add(supply, amount)
+	} else {
+		supply.Sub(supply, amount)
+	}
+	supplies[blockNumber] = supply
+}
+
+// latestSupply returns the highest block a supply was recorded in
+func latestSupply(supplies map[uint64]*big.Int) (uint64, bool) {
+	var latest uint64
+	found := false
+	for blockNumber := range supplies {
+		if !found || blockNumber > latest {
+			latest, found = blockNumber, true
+		}
+	}
+	return latest, found
+}
+
+// spendAllowance deducts a transferFrom from the allowance of its caller, unless the
+// allowance is unlimited or was set by an Approval in the same transaction
+func (m *MemoryDB) spendAllowance(contractAddress, owner, spender string, amount *big.Int, blockNumber uint64, txHash string) {
+	key := [3]string{contractAddress, owner, spender}
+	allowance, ok := m.allowances[key]
+	if !ok || allowance.txHash == txHash || allowance.amount.Cmp(UnlimitedAllowance) == 0 {
+		return
+	}
+	remaining := new(big.Int).Sub(allowance.amount, amount)
+	if remaining.Sign() < 0 {
+		remaining.SetInt64(0)
+	}
+	m.allowances[key] = memoryAllowance{amount: remaining, blockNumber: blockNumber, txHash: allowance.txHash}
+}
+
+// GetBalance returns the balance of a token held by an address
+func (m *MemoryDB) GetBalance(contractAddress, holder string) (*big.Int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return copyOrZero(m.balances[[2]string{contractAddress, holder}]), nil
+}
+
+// copyOrZero returns a copy of n, or zero if n is nil
+func copyOrZero(n *big.Int) *big.Int {
+	if n == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(n)
+}
+
+// ReplayBalances recomputes the balances of a token from its Transfer events up to and
+// including the given block
+func (m *MemoryDB) ReplayBalances(contractAddress string, toBlock uint64) (map[string]*big.Int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	balances := make(map[string]*big.Int)
+	add := func(holder *string, amount *big.Int) {
+		if holder == nil || *holder == zeroAddress {
+			return
+		}
+		balance, ok := balances[*holder]
+		if !ok {
+			balance = new(big.Int)
+			balances[*holder] = balance
+		}
+		balance.Add(balance, amount)
+	}
+	for _, event := range m.events {
+		if event.ContractAddress != contractAddress || !isTransfer(event.EventType) || event.BlockNumber > toBlock || event.Value == nil {
+			continue
+		}
+		add(event.From, new(big.Int).Neg(event.Value))
+		add(event.To, event.Value)
+	}
+	return balances, nil
+}
+
+// GetAllowance returns the amount a spender may still transfer on behalf of an owner
+func (m *MemoryDB) GetAllowance(contractAddress, owner, spender string) (*big.Int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return copyOrZero(m.allowances[[3]string{contractAddress, owner, spender}].amount), nil
+}
+
+// GetUnlimitedApprovals returns the unlimited allowances granted by an owner
+func (m *MemoryDB) GetUnlimitedApprovals(owner string) ([]Allowance, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var allowances []Allowance
+	for key, a := range m.allowances {
+		if key[1] != owner || a.amount.Cmp(UnlimitedAllowance) != 0 {
+			continue
+		}
+		allowances = append(allowances, Allowance{
+			ContractAddress: key[0],
+			Owner:           owner,
+			Spender:         key[2],
+			Amount:          new(big.Int).Set(UnlimitedAllowance),
+			BlockNumber:     a.blockNumber,
+		})
+	}
+	sort.Slice(allowances, func(i, j int) bool {
+		if allowances[i].ContractAddress != allowances[j].ContractAddress {
+			return allowances[i].ContractAddress < allowances[j].ContractAddress
+		}
+		return allowances[i].Spender < allowances[j].Spender
+	})
+	return allowances, nil
+}
+
+// GetTotalSupply returns the latest total supply of a token and the block it changed in,
+// or false if no mint or burn has been stored
+func (m *MemoryDB) GetTotalSupply(contractAddress string) (uint64, *big.Int, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	supplies := m.supplies[contractAddress]
+	latest, ok := latestSupply(supplies)
+	if !ok {
+		return 0, nil, false, nil
+	}
+	return latest, new(big.Int).Set(supplies[latest]), true, nil
+}
+
+// SaveToken stores the metadata of a token, replacing any previous metadata
+func (m *MemoryDB) SaveToken(token Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token.UpdatedAt = time.Now().UTC()
+	m.tokens[token.ContractAddress] = token
+	return nil
+}
+
+// GetToken returns the stored metadata of a token, or false if it has not been saved
+func (m *MemoryDB) GetToken(contractAddress string) (Token, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	token, ok := m.tokens[contractAddress]
+	return token, ok, nil
+}
+
+// SaveContractEvent stores a generically decoded event and advances the sync checkpoint
+// of its contract, skipping events already stored
+func (m *MemoryDB) SaveContractEvent(event ContractEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.advanceCheckpoint(event.ContractAddress, event.BlockNumber)
+	key := eventKey{event.BlockHash, event.TxHash, event.LogIndex}
+	if !m.contractKeys[key] {
+		m.contractKeys[key] = true
+		m.contractEvents = append(m.contractEvents, event)
+	}
+	return nil
+}
+
+// SaveNFTEvents stores the non-fungible token events of a log, moving tokens and
+// balances, and advances the sync checkpoint of their contract. Events already stored
+// are skipped.
+func (m *MemoryDB) SaveNFTEvents(events []NFTEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, event := range events {
+		m.advanceCheckpoint(event.ContractAddress, event.BlockNumber)
+		key := nftEventKey{eventKey{event.BlockHash, event.TxHash, event.LogIndex}, i}
+		if m.nftKeys[key] {
+			continue
+		}
+		m.nftKeys[key] = true
+		m.nftEvents = append(m.nftEvents, memoryNFTEvent{event, key})
+		m.applyNFTEvent(event)
+	}
+	return nil
+}
+
+// applyNFTEvent updates the owners and balances changed by a non-fungible token event
+func (m *MemoryDB) applyNFTEvent(event NFTEvent) {
+	switch {
+	case event.EventType == "Transfer" && event.TokenID != nil && event.To != nil:
+		key := [2]string{event.ContractAddress, event.TokenID.String()}
+		if *event.To == zeroAddress {
+			delete(m.owners, key)
+		} else {
+			m.owners[key] = *event.To
+		}
+	case isMultiTokenTransfer(event.EventType) && event.TokenID != nil && event.Value != nil:
+		tokenID := event.TokenID.String()
+		if event.From != nil && *event.From != zeroAddress {
+			addTo(m.nftBalances, [3]string{event.ContractAddress, tokenID, *event.From}, new(big.Int).Neg(event.Value))
+		}
+		if event.To != nil && *event.To != zeroAddress {
+			addTo(m.nftBalances, [3]string{event.ContractAddress, tokenID, *event.To}, event.Value)
+		}
+	}
+}
+
+// GetTokenOwner returns the current owner of a non-fungible token, or false if the
+// token was never minted or has been burnt
+func (m *MemoryDB) GetTokenOwner(contractAddress string, tokenID *big.Int) (string, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	owner, ok := m.owners[[2]string{contractAddress, tokenID.String()}]
+	return owner, ok, nil
+}
+
+// GetNFTBalance returns the balance of a multi-token held by an address
+func (m *MemoryDB) GetNFTBalance(contractAddress string, tokenID *big.Int, holder string) (*big.Int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return copyOrZero(m.nftBalances[[3]string{contractAddress, tokenID.String(), holder}]), nil
+}
+
+// QuarantineLog stores a log that could not be decoded and advances the sync checkpoint
+// of its contract past it. Logs already stored are skipped.
+func (m *MemoryDB) QuarantineLog(log QuarantinedLog) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.advanceCheckpoint(log.ContractAddress, log.BlockNumber)
+	key := eventKey{log.BlockHash, log.TxHash, log.LogIndex}
+	if !m.quarantineKeys[key] {
+		m.quarantineKeys[key] = true
+		m.quarantined = append(m.quarantined, log)
+	}
+	return nil
+}
+
+// GetCheckpoint returns the last processed block number of a contract, or false if
+// nothing has been stored for it yet
+func (m *MemoryDB) GetCheckpoint(contractAddress string) (uint64, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	checkpoint, ok := m.checkpoints[contractAddress]
+	return checkpoint, ok, nil
+}
+
+// SaveBlock records the hash of a block that events were indexed from
+func (m *MemoryDB) SaveBlock(blockNumber uint64, blockHash, parentHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blocks[blockNumber] = [2]string{blockHash, parentHash}
+	return nil
+}
+
+// GetBlockHash returns the stored hash of the given block, or false if it is not known
+func (m *MemoryDB) GetBlockHash(blockNumber uint64) (string, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	block, ok := m.blocks[blockNumber]
+	return block[0], ok, nil
+}
+
+// LatestBlockBefore returns the highest stored block below the given block number and its hash
+func (m *MemoryDB) LatestBlockBefore(blockNumber uint64) (uint64, string, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var latest uint64
+	found := false
+	for number := range m.blocks {
+		if number < blockNumber && (!found || number > latest) {
+			latest, found = number, true
+		}
+	}
+	return latest, m.blocks[latest][0], found, nil
+}
+
+// Rollback deletes all events and blocks from the given block onwards, rebuilds the
+// state derived from the remaining events and moves the sync checkpoints back before it
+func (m *MemoryDB) Rollback(fromBlock uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := m.events
+	m.events, m.eventKeys = nil, make(map[eventKey]bool)
+	nftEvents := m.nftEvents
+	m.nftEvents, m.nftKeys = nil, make(map[nftEventKey]bool)
+	m.resetDerived()
+
+	for _, event := range events {
+		if event.BlockNumber < fromBlock {
+			m.eventKeys[eventKey{event.BlockHash, event.TxHash, event.LogIndex}] = true
+			m.events = append(m.events, event)
+			m.applyEvent(event)
+		}
+	}
+	for _, event := range nftEvents {
+		if event.BlockNumber < fromBlock {
+			m.nftKeys[event.key] = true
+			m.nftEvents = append(m.nftEvents, event)
+			m.applyNFTEvent(event.NFTEvent)
+		}
+	}
+
+	contractEvents := m.contractEvents
+	m.contractEvents = nil
+	for _, event := range contractEvents {
+		if event.BlockNumber < fromBlock {
+			m.contractEvents = append(m.contractEvents, event)
+		} else {
+			delete(m.contractKeys, eventKey{event.BlockHash, event.TxHash, event.LogIndex})
+		}
+	}
+	quarantined := m.quarantined
+	m.quarantined = nil
+	for _, log := range quarantined {
+		if log.BlockNumber < fromBlock {
+			m.quarantined = append(m.quarantined, log)
+		} else {
+			delete(m.quarantineKeys, eventKey{log.BlockHash, log.TxHash, log.LogIndex})
+		}
+	}
+
+	for number := range m.blocks {
+		if number >= fromBlock {
+			delete(m.blocks, number)
+		}
+	}
+	for contractAddress, checkpoint := range m.checkpoints {
+		switch {
+		case fromBlock == 0:
+			delete(m.checkpoints, contractAddress)
+		case checkpoint >= fromBlock:
+			m.checkpoints[contractAddress] = fromBlock - 1
+		}
+	}
+	return nil
+}
+
+// QueryEvents returns a page of the ERC-20 events matching a query in chain order
+func (m *MemoryDB) QueryEvents(query EventQuery) (EventPage, error) {
+	after, err := parseCursor(query.Cursor)
+	if err != nil {
+		return EventPage{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []Event
+	for _, event := range m.events {
+		if query.matches(event) && (after == nil || after.less(cursorOf(event))) {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return cursorOf(events[i]).less(cursorOf(events[j])) })
+	return newEventPage(events, query.limit()), nil
+}
+
+// Close does nothing, as there is no connection to close
+func (m *MemoryDB) Close() error {
+	return nil
+}
+
+// Events returns the stored ERC-20 events in the order they were saved
+func (m *MemoryDB) Events() []Event {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Event(nil), m.events...)
+}
+
+// ContractEvents returns the stored generically decoded events in the order they were saved
+func (m *MemoryDB) ContractEvents() []ContractEvent {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]ContractEvent(nil), m.contractEvents...)
+}
+
+// NFTEvents returns the stored non-fungible token events in the order they were saved
+func (m *MemoryDB) NFTEvents() []NFTEvent {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	events := make([]NFTEvent, len(m.nftEvents))
+	for i, event := range m.nftEvents {
+		events[i] = event.NFTEvent
+	}
+	return events
+}
+
+// QuarantinedLogs returns the stored logs that could not be decoded
+func (m *MemoryDB) QuarantinedLogs() []QuarantinedLog {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]QuarantinedLog(nil), m.quarantined...)
+}
+
+// Balances returns the non-zero balances of every holder of a token
+func (m *MemoryDB) Balances(contractAddress string) map[string]*big.Int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	balances := make(map[string]*big.Int)
+	for key, balance := range m.balances {
+		if key[0] == contractAddress {
+			balances[key[1]] = new(big.Int).Set(balance)
+		}
+	}
+	return balances
+}
+
+// Checkpoints returns the sync checkpoint of every contract
+func (m *MemoryDB) Checkpoints() map[string]uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	checkpoints := make(map[string]uint64, len(m.checkpoints))
+	for contractAddress, checkpoint := range m.checkpoints {
+		checkpoints[contractAddress] = checkpoint
+	}
+	return checkpoints
+}
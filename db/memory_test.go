@@ -0,0 +1,185 @@
+package db
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testStore runs the same events through a store and checks the state it derives, so
+// every Interface implementation behaves alike
+func testStore(t *testing.T, store Interface) {
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	collection := "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D"
+	alice := "0x1234567890abcdef1234567890abcdef12345678"
+	bob := "0x1234567890abcdef1234567890abcdef12345679"
+	carol := "0x1234567890abcdef1234567890abcdef1234567a"
+	zero := zeroAddress
+
+	event := func(blockNumber uint64, logIndex uint, eventType string, from, to, owner, spender *string, value *big.Int) Event {
+		return Event{
+			ContractAddress: contractAddress,
+			BlockNumber:     blockNumber,
+			BlockHash:       testBlockHash(blockNumber),
+			TxHash:          testBlockHash(blockNumber + 1000),
+			LogIndex:        logIndex,
+			EventType:       eventType,
+			From:            from,
+			To:              to,
+			Owner:           owner,
+			Spender:         spender,
+			Value:           value,
+		}
+	}
+	block10 := []Event{
+		event(10, 0, "Mint", &zero, &alice, nil, nil, big.NewInt(100)),
+		event(10, 1, "Approval", nil, nil, &alice, &bob, big.NewInt(50)),
+	}
+	block11 := []Event{
+		event(11, 0, "Transfer", &alice, &carol, nil, &bob, big.NewInt(20)),
+		event(11, 1, "Approval", nil, nil, &alice, &carol, UnlimitedAllowance),
+	}
+	burn := event(12, 0, "Burn", &carol, &zero, nil, nil, big.NewInt(5))
+
+	assert.Nil(t, store.SaveEvents(block10))
+	assert.Nil(t, store.SaveEvents(block11))
+	assert.Nil(t, store.SaveEvent(burn))
+	// Replayed logs are skipped
+	assert.Nil(t, store.SaveEvents(block11))
+
+	mint := testNFTTransfer(collection, 11, zero, alice, 1)
+	batch := []NFTEvent{
+		testMultiTokenTransfer(collection, 12, zero, bob, 1, 10),
+		testMultiTokenTransfer(collection, 12, zero, bob, 2, 20),
+	}
+	assert.Nil(t, store.SaveNFTEvents([]NFTEvent{mint}))
+	assert.Nil(t, store.SaveNFTEvents(batch))
+	assert.Nil(t, store.SaveNFTEvents(batch))
+	for number := uint64(10); number <= 12; number++ {
+		assert.Nil(t, store.SaveBlock(number, testBlockHash(number), testBlockHash(number-1)))
+	}
+
+	balance := func(holder string) string {
+		b, err := store.GetBalance(contractAddress, holder)
+		assert.Nil(t, err, "Error should be nil")
+		return b.String()
+	}
+	allowance := func(spender string) string {
+		a, err := store.GetAllowance(contractAddress, alice, spender)
+		assert.Nil(t, err, "Error should be nil")
+		return a.String()
+	}
+	supply := func() (uint64, string) {
+		block, s, ok, err := store.GetTotalSupply(contractAddress)
+		assert.Nil(t, err, "Error should be nil")
+		assert.True(t, ok)
+		return block, s.String()
+	}
+	nftBalance := func(tokenID int64) string {
+		b, err := store.GetNFTBalance(collection, big.NewInt(tokenID), bob)
+		assert.Nil(t, err, "Error should be nil")
+		return b.String()
+	}
+	checkpoint := func(address string) uint64 {
+		c, _, err := store.GetCheckpoint(address)
+		assert.Nil(t, err, "Error should be nil")
+		return c
+	}
+
+	assert.Equal(t, "80", balance(alice))
+	assert.Equal(t, "15", balance(carol))
+	assert.Equal(t, "30", allowance(bob), "transferFrom should consume the allowance")
+	block, s := supply()
+	assert.Equal(t, uint64(12), block)
+	assert.Equal(t, "95", s)
+	approvals, err := store.GetUnlimitedApprovals(alice)
+	assert.Nil(t, err, "Error should be nil")
+	if assert.Len(t, approvals, 1) {
+		assert.Equal(t, carol, approvals[0].Spender)
+		assert.Equal(t, uint64(11), approvals[0].BlockNumber)
+	}
+	replayed, err := store.ReplayBalances(contractAddress, 11)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "20", replayed[carol].String())
+	owner, ok, err := store.GetTokenOwner(collection, big.NewInt(1))
+	assert.Nil(t, err, "Error should be nil")
+	assert.True(t, ok)
+	assert.Equal(t, alice, owner)
+	assert.Equal(t, "20", nftBalance(2), "Replayed batches should not be applied again")
+	assert.Equal(t, uint64(12), checkpoint(contractAddress))
+	number, hash, ok, err := store.LatestBlockBefore(12)
+	assert.Nil(t, err, "Error should be nil")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(11), number)
+	assert.Equal(t, testBlockHash(11), hash)
+
+	// Rolling back block 12 reverts the burn and the batch
+	assert.Nil(t, store.Rollback(12))
+	assert.Equal(t, "20", balance(carol))
+	block, s = supply()
+	assert.Equal(t, uint64(10), block)
+	assert.Equal(t, "100", s)
+	assert.Equal(t, "0", nftBalance(2))
+	assert.Equal(t, uint64(11), checkpoint(contractAddress))
+	assert.Equal(t, uint64(11), checkpoint(collection))
+	_, ok, err = store.GetBlockHash(12)
+	assert.Nil(t, err, "Error should be nil")
+	assert.False(t, ok)
+
+	// Rolled back logs are stored again when the block is re-indexed
+	assert.Nil(t, store.SaveEvent(burn))
+	assert.Equal(t, "15", balance(carol))
+	assert.Nil(t, store.Rollback(11))
+	assert.Equal(t, "100", balance(alice))
+	assert.Equal(t, "50", allowance(bob), "Rollbacks should restore spent allowances")
+	approvals, err = store.GetUnlimitedApprovals(alice)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Empty(t, approvals)
+	_, ok, err = store.GetTokenOwner(collection, big.NewInt(1))
+	assert.Nil(t, err, "Error should be nil")
+	assert.False(t, ok, "Rolled back mints should have no owner")
+
+	decimals := uint8(6)
+	assert.Nil(t, store.SaveToken(Token{ContractAddress: contractAddress, Decimals: &decimals}))
+	token, ok, err := store.GetToken(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.True(t, ok)
+	assert.Equal(t, uint8(6), *token.Decimals)
+	assert.Nil(t, token.Name)
+
+	assert.Nil(t, store.Close())
+}
+
+func TestStores(t *testing.T) {
+	t.Run("DB", func(t *testing.T) { testStore(t, InitTestDB()) })
+	t.Run("MemoryDB", func(t *testing.T) { testStore(t, NewMemoryDB()) })
+	t.Run("BoltDB", func(t *testing.T) { testStore(t, testBoltDB(t)) })
+}
+
+func TestMemoryDB_Queries(t *testing.T) {
+	store := NewMemoryDB()
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+
+	assert.Nil(t, store.SaveEvent(testTransfer(contractAddress, 10)))
+	assert.Nil(t, store.SaveEvent(testTransfer(contractAddress, 11)))
+	assert.Nil(t, store.SaveEvent(testTransfer(contractAddress, 11)))
+	assert.Nil(t, store.SaveContractEvent(ContractEvent{ContractAddress: contractAddress, BlockNumber: 12, EventName: "Paused"}))
+	assert.Nil(t, store.QuarantineLog(QuarantinedLog{ContractAddress: contractAddress, BlockNumber: 13, Reason: "malformed"}))
+
+	events := store.Events()
+	assert.Len(t, events, 2, "Replayed events should be stored once")
+	assert.Equal(t, uint64(10), events[0].BlockNumber)
+	assert.Len(t, store.ContractEvents(), 1)
+	assert.Len(t, store.QuarantinedLogs(), 1)
+	assert.Empty(t, store.NFTEvents())
+
+	balances := store.Balances(contractAddress)
+	assert.Equal(t, "2", balances[*events[0].To].String())
+	assert.Equal(t, "-2", balances[*events[0].From].String())
+	assert.Equal(t, map[string]uint64{contractAddress: 13}, store.Checkpoints())
+
+	// Returned values are copies
+	balances[*events[0].To].SetInt64(0)
+	assert.Equal(t, "2", store.Balances(contractAddress)[*events[0].To].String())
+}
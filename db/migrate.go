@@ -0,0 +1,228 @@
+package db
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// migrationFiles holds the schema migrations. Every migration is a pair of files named
+// <version>_<name>.up.sql and <version>_<name>.down.sql, which are templates executed
+// with the dialect of the database.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a versioned change to the database schema. Migrations are applied in
+// order of their version and reverted in reverse order.
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus reports whether a migration has been applied to the database
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// loadMigrations reads the embedded migrations for a dialect, ordered by version
+func loadMigrations(d dialect) ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+
+		sql, err := renderMigration(entry.Name(), d)
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.up = sql
+		} else {
+			m.down = sql
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// renderMigration executes a migration file for a dialect
+func renderMigration(name string, d dialect) (string, error) {
+	tmpl, err := template.ParseFS(migrationFiles, path.Join("migrations", name))
+	if err != nil {
+		return "", err
+	}
+	var sql bytes.Buffer
+	if err := tmpl.Execute(&sql, d); err != nil {
+		return "", fmt.Errorf("failed to render migration %s: %v", name, err)
+	}
+	return sql.String(), nil
+}
+
+// appliedMigrations creates the schema_migrations table if needed and returns the
+// versions it records along with the time they were applied
+func (db *DB) appliedMigrations() (map[int]time.Time, error) {
+	_, err := db.conn.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrateUp applies the pending migrations in order, each in its own transaction, and
+// returns the migrations it applied
+func (db *DB) MigrateUp() ([]Migration, error) {
+	migrations, err := loadMigrations(db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := db.runMigration(m.up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name); err != nil {
+			return done, fmt.Errorf("migration %d_%s failed: %v", m.Version, m.Name, err)
+		}
+		log.Printf("Applied migration %d_%s", m.Version, m.Name)
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the given number of most recently applied migrations and returns
+// the migrations it reverted
+func (db *DB) MigrateDown(steps int) ([]Migration, error) {
+	migrations, err := loadMigrations(db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	if steps < len(versions) {
+		versions = versions[:steps]
+	}
+
+	var done []Migration
+	for _, version := range versions {
+		i := sort.Search(len(migrations), func(i int) bool { return migrations[i].Version >= version })
+		if i == len(migrations) || migrations[i].Version != version {
+			return done, fmt.Errorf("applied migration %d is unknown to this build", version)
+		}
+		m := migrations[i]
+		if err := db.runMigration(m.down, `DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+			return done, fmt.Errorf("reverting migration %d_%s failed: %v", m.Version, m.Name, err)
+		}
+		log.Printf("Reverted migration %d_%s", m.Version, m.Name)
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// runMigration executes the SQL of a migration and records it in schema_migrations in
+// a single transaction
+func (db *DB) runMigration(sql, record string, args ...interface{}) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(sql); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrationStatus returns every known migration in order and whether it has been applied
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		statuses[i] = MigrationStatus{Migration: m, Applied: ok, AppliedAt: appliedAt}
+	}
+	return statuses, nil
+}
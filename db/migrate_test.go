@@ -0,0 +1,62 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(postgres)
+	assert.Nil(t, err, "Error should be nil")
+	assert.NotEmpty(t, migrations)
+	for i := 1; i < len(migrations); i++ {
+		assert.Less(t, migrations[i-1].Version, migrations[i].Version, "Migrations should be ordered by version")
+	}
+	assert.Contains(t, migrations[0].up, "SERIAL PRIMARY KEY")
+	assert.Contains(t, migrations[0].up, "ADD COLUMN IF NOT EXISTS")
+
+	migrations, err = loadMigrations(sqlite)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Contains(t, migrations[0].up, "AUTOINCREMENT")
+	assert.NotContains(t, migrations[0].up, "ADD COLUMN IF NOT EXISTS", "SQLite does not support ADD COLUMN IF NOT EXISTS")
+}
+
+func TestMigrations(t *testing.T) {
+	db, err := Open("sqlite://:memory:")
+	assert.Nil(t, err, "Error should be nil")
+
+	statuses, err := db.MigrationStatus()
+	assert.Nil(t, err, "Error should be nil")
+	for _, s := range statuses {
+		assert.False(t, s.Applied, "No migration should be applied to a new database")
+	}
+
+	applied, err := db.MigrateUp()
+	assert.Nil(t, err, "Error should be nil")
+	assert.Len(t, applied, len(statuses))
+	assert.Nil(t, db.SaveEvent(testTransfer("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", 1)))
+
+	applied, err = db.MigrateUp()
+	assert.Nil(t, err, "Error should be nil")
+	assert.Empty(t, applied, "Applied migrations should not run again")
+
+	statuses, err = db.MigrationStatus()
+	assert.Nil(t, err, "Error should be nil")
+	for _, s := range statuses {
+		assert.True(t, s.Applied)
+		assert.False(t, s.AppliedAt.IsZero())
+	}
+
+	reverted, err := db.MigrateDown(len(statuses) + 1)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Len(t, reverted, len(statuses), "Only applied migrations should be reverted")
+	_, err = db.conn.Exec(`SELECT 1 FROM erc20_events`)
+	assert.Error(t, err, "Reverted tables should be dropped")
+
+	statuses, err = db.MigrationStatus()
+	assert.Nil(t, err, "Error should be nil")
+	for _, s := range statuses {
+		assert.False(t, s.Applied)
+	}
+}
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS blocks;
+DROP TABLE IF EXISTS sync_checkpoint;
+DROP TABLE IF EXISTS quarantined_logs;
+DROP TABLE IF EXISTS nft_balances;
+DROP TABLE IF EXISTS nft_owners;
+DROP TABLE IF EXISTS nft_events;
+DROP TABLE IF EXISTS contract_events;
+DROP TABLE IF EXISTS allowances;
+DROP TABLE IF EXISTS tokens;
+DROP TABLE IF EXISTS token_supply;
+DROP TABLE IF EXISTS balances;
+DROP TABLE IF EXISTS erc20_events;
@@ -0,0 +1,139 @@
+CREATE TABLE IF NOT EXISTS erc20_events (
+	id {{.AutoIncrement}},
+	contract_address VARCHAR(42),
+	block_number BIGINT NOT NULL,
+	block_hash VARCHAR(66),
+	tx_hash VARCHAR(66) NOT NULL,
+	log_index INTEGER,
+	event_type VARCHAR(50) NOT NULL,
+	from_address VARCHAR(42),
+	to_address VARCHAR(42),
+	owner_address VARCHAR(42),
+	spender_address VARCHAR(42),
+	value {{.Numeric}},
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+{{- if .Postgres}}
+-- Columns added before schema migrations were introduced
+ALTER TABLE erc20_events ADD COLUMN IF NOT EXISTS contract_address VARCHAR(42);
+ALTER TABLE erc20_events ADD COLUMN IF NOT EXISTS block_hash VARCHAR(66);
+ALTER TABLE erc20_events ADD COLUMN IF NOT EXISTS log_index INTEGER;
+{{- end}}
+CREATE INDEX IF NOT EXISTS erc20_events_contract_address_idx ON erc20_events (contract_address, block_number);
+CREATE UNIQUE INDEX IF NOT EXISTS erc20_events_log_idx ON erc20_events (block_hash, tx_hash, log_index);
+
+CREATE TABLE IF NOT EXISTS balances (
+	contract_address VARCHAR(42) NOT NULL,
+	holder_address VARCHAR(42) NOT NULL,
+	balance {{.Numeric}} NOT NULL,
+	PRIMARY KEY (contract_address, holder_address)
+);
+CREATE INDEX IF NOT EXISTS balances_holder_idx ON balances (holder_address);
+
+CREATE TABLE IF NOT EXISTS token_supply (
+	contract_address VARCHAR(42) NOT NULL,
+	block_number BIGINT NOT NULL,
+	total_supply {{.Numeric}} NOT NULL,
+	PRIMARY KEY (contract_address, block_number)
+);
+
+CREATE TABLE IF NOT EXISTS tokens (
+	contract_address VARCHAR(42) PRIMARY KEY,
+	name TEXT,
+	symbol TEXT,
+	decimals SMALLINT,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS allowances (
+	contract_address VARCHAR(42) NOT NULL,
+	owner_address VARCHAR(42) NOT NULL,
+	spender_address VARCHAR(42) NOT NULL,
+	amount {{.Numeric}} NOT NULL,
+	block_number BIGINT NOT NULL,
+	tx_hash VARCHAR(66) NOT NULL,
+	PRIMARY KEY (contract_address, owner_address, spender_address)
+);
+CREATE INDEX IF NOT EXISTS allowances_owner_idx ON allowances (owner_address);
+
+CREATE TABLE IF NOT EXISTS contract_events (
+	id {{.AutoIncrement}},
+	contract_address VARCHAR(42) NOT NULL,
+	block_number BIGINT NOT NULL,
+	block_hash VARCHAR(66),
+	tx_hash VARCHAR(66) NOT NULL,
+	log_index INTEGER NOT NULL,
+	event_name VARCHAR(100) NOT NULL,
+	event_signature TEXT NOT NULL,
+	args {{.JSON}} NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS contract_events_contract_address_idx ON contract_events (contract_address, event_name, block_number);
+CREATE UNIQUE INDEX IF NOT EXISTS contract_events_log_idx ON contract_events (block_hash, tx_hash, log_index);
+
+CREATE TABLE IF NOT EXISTS nft_events (
+	id {{.AutoIncrement}},
+	contract_address VARCHAR(42) NOT NULL,
+	block_number BIGINT NOT NULL,
+	block_hash VARCHAR(66),
+	tx_hash VARCHAR(66) NOT NULL,
+	log_index INTEGER NOT NULL,
+	batch_index INTEGER NOT NULL DEFAULT 0,
+	event_type VARCHAR(50) NOT NULL,
+	from_address VARCHAR(42),
+	to_address VARCHAR(42),
+	owner_address VARCHAR(42),
+	approved_address VARCHAR(42),
+	operator_address VARCHAR(42),
+	token_id {{.Numeric}},
+	value {{.Numeric}},
+	approved_for_all BOOLEAN,
+	uri TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS nft_events_token_idx ON nft_events (contract_address, token_id);
+CREATE UNIQUE INDEX IF NOT EXISTS nft_events_log_idx ON nft_events (block_hash, tx_hash, log_index, batch_index);
+
+CREATE TABLE IF NOT EXISTS nft_owners (
+	contract_address VARCHAR(42) NOT NULL,
+	token_id {{.Numeric}} NOT NULL,
+	owner_address VARCHAR(42) NOT NULL,
+	block_number BIGINT NOT NULL,
+	PRIMARY KEY (contract_address, token_id)
+);
+CREATE INDEX IF NOT EXISTS nft_owners_owner_idx ON nft_owners (owner_address);
+
+CREATE TABLE IF NOT EXISTS nft_balances (
+	contract_address VARCHAR(42) NOT NULL,
+	token_id {{.Numeric}} NOT NULL,
+	holder_address VARCHAR(42) NOT NULL,
+	balance {{.Numeric}} NOT NULL,
+	PRIMARY KEY (contract_address, token_id, holder_address)
+);
+CREATE INDEX IF NOT EXISTS nft_balances_holder_idx ON nft_balances (holder_address);
+
+CREATE TABLE IF NOT EXISTS quarantined_logs (
+	id {{.AutoIncrement}},
+	contract_address VARCHAR(42) NOT NULL,
+	block_number BIGINT NOT NULL,
+	block_hash VARCHAR(66) NOT NULL,
+	tx_hash VARCHAR(66) NOT NULL,
+	log_index INTEGER NOT NULL,
+	topics TEXT NOT NULL,
+	data TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS quarantined_logs_log_idx ON quarantined_logs (block_hash, tx_hash, log_index);
+
+CREATE TABLE IF NOT EXISTS sync_checkpoint (
+	contract_address VARCHAR(42) PRIMARY KEY,
+	block_number BIGINT NOT NULL,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS blocks (
+	block_number BIGINT PRIMARY KEY,
+	block_hash VARCHAR(66) NOT NULL,
+	parent_hash VARCHAR(66)
+);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS erc20_events_type_idx;
+DROP INDEX IF EXISTS erc20_events_tx_hash_idx;
+DROP INDEX IF EXISTS erc20_events_spender_idx;
+DROP INDEX IF EXISTS erc20_events_owner_idx;
+DROP INDEX IF EXISTS erc20_events_to_idx;
+DROP INDEX IF EXISTS erc20_events_from_idx;
+DROP INDEX IF EXISTS erc20_events_position_idx;
@@ -0,0 +1,9 @@
+-- Indexes of the event queries, which return events in chain order. Queries order and
+-- page on the expressions of erc20_events_position_idx, so the index must match them.
+CREATE INDEX IF NOT EXISTS erc20_events_position_idx ON erc20_events (block_number, COALESCE(log_index, 0), COALESCE(block_hash, ''), tx_hash);
+CREATE INDEX IF NOT EXISTS erc20_events_from_idx ON erc20_events (from_address, block_number);
+CREATE INDEX IF NOT EXISTS erc20_events_to_idx ON erc20_events (to_address, block_number);
+CREATE INDEX IF NOT EXISTS erc20_events_owner_idx ON erc20_events (owner_address, block_number);
+CREATE INDEX IF NOT EXISTS erc20_events_spender_idx ON erc20_events (spender_address, block_number);
+CREATE INDEX IF NOT EXISTS erc20_events_tx_hash_idx ON erc20_events (tx_hash);
+CREATE INDEX IF NOT EXISTS erc20_events_type_idx ON erc20_events (event_type, block_number);
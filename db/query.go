@@ -0,0 +1,217 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultEventLimit is the page size of event queries that do not set a limit
+const DefaultEventLimit = 100
+
+// MaxEventLimit is the largest page of events a query returns
+const MaxEventLimit = 1000
+
+// ErrInvalidCursor is returned for a query whose cursor was not returned by a previous page
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EventQuery selects indexed ERC-20 events. Empty fields match any event, Address
+// matches the sender, recipient, owner or spender of an event, EventTypes any of the
+// listed types and a zero ToBlock any block from FromBlock onwards. Events are returned
+// in chain order, at most Limit at a time, starting after the event Cursor points at.
+type EventQuery struct {
+	ContractAddress string
+	Address         string
+	TxHash          string
+	EventTypes      []string
+	FromBlock       uint64
+	ToBlock         uint64
+	Cursor          string
+	Limit           int
+}
+
+// EventPage is a page of the events matching a query. NextCursor continues the query
+// after the page and is empty on the last page.
+type EventPage struct {
+	Events     []Event
+	NextCursor string
+}
+
+// limit returns the page size of a query
+func (q EventQuery) limit() int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultEventLimit
+	case q.Limit > MaxEventLimit:
+		return MaxEventLimit
+	}
+	return q.Limit
+}
+
+// matches reports whether an event matches the filters of a query, ignoring its cursor
+func (q EventQuery) matches(event Event) bool {
+	involves := func(address *string) bool { return address != nil && *address == q.Address }
+	switch {
+	case q.ContractAddress != "" && event.ContractAddress != q.ContractAddress,
+		q.Address != "" && !involves(event.From) && !involves(event.To) && !involves(event.Owner) && !involves(event.Spender),
+		q.TxHash != "" && event.TxHash != q.TxHash,
+		len(q.EventTypes) > 0 && !slices.Contains(q.EventTypes, event.EventType),
+		event.BlockNumber < q.FromBlock,
+		q.ToBlock > 0 && event.BlockNumber > q.ToBlock:
+		return false
+	}
+	return true
+}
+
+// eventCursor is the position of an event in chain order, which sorts events by block
+// number, log index, block hash and transaction hash
+type eventCursor struct {
+	blockNumber uint64
+	logIndex    uint
+	blockHash   string
+	txHash      string
+}
+
+// cursorOf returns the position of an event
+func cursorOf(event Event) eventCursor {
+	return eventCursor{event.BlockNumber, event.LogIndex, event.BlockHash, event.TxHash}
+}
+
+// less reports whether c comes before o in chain order
+func (c eventCursor) less(o eventCursor) bool {
+	switch {
+	case c.blockNumber != o.blockNumber:
+		return c.blockNumber < o.blockNumber
+	case c.logIndex != o.logIndex:
+		return c.logIndex < o.logIndex
+	case c.blockHash != o.blockHash:
+		return c.blockHash < o.blockHash
+	}
+	return c.txHash < o.txHash
+}
+
+// String encodes the cursor for EventPage.NextCursor
+func (c eventCursor) String() string {
+	raw := fmt.Sprintf("%d:%d:%s:%s", c.blockNumber, c.logIndex, c.blockHash, c.txHash)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parseCursor decodes the cursor of a query, or returns nil for the first page
+func parseCursor(s string) (*eventCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	fields := strings.SplitN(string(raw), ":", 4)
+	if len(fields) != 4 {
+		return nil, ErrInvalidCursor
+	}
+	blockNumber, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	logIndex, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &eventCursor{blockNumber, uint(logIndex), fields[2], fields[3]}, nil
+}
+
+// newEventPage returns the first limit events as a page, which continues after the
+// last of them if there are more
+func newEventPage(events []Event, limit int) EventPage {
+	if len(events) <= limit {
+		return EventPage{Events: events}
+	}
+	events = events[:limit]
+	return EventPage{Events: events, NextCursor: cursorOf(events[limit-1]).String()}
+}
+
+// QueryEvents returns a page of the ERC-20 events matching a query in chain order
+func (db *DB) QueryEvents(query EventQuery) (EventPage, error) {
+	after, err := parseCursor(query.Cursor)
+	if err != nil {
+		return EventPage{}, err
+	}
+
+	var conditions []string
+	var args []any
+	param := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if query.ContractAddress != "" {
+		conditions = append(conditions, "contract_address = "+param(query.ContractAddress))
+	}
+	if query.Address != "" {
+		conditions = append(conditions, fmt.Sprintf(
+			"(from_address = %[1]s OR to_address = %[1]s OR owner_address = %[1]s OR spender_address = %[1]s)",
+			param(query.Address),
+		))
+	}
+	if query.TxHash != "" {
+		conditions = append(conditions, "tx_hash = "+param(query.TxHash))
+	}
+	if len(query.EventTypes) > 0 {
+		types := make([]string, len(query.EventTypes))
+		for i, eventType := range query.EventTypes {
+			types[i] = param(eventType)
+		}
+		conditions = append(conditions, "event_type IN ("+strings.Join(types, ", ")+")")
+	}
+	if query.FromBlock > 0 {
+		conditions = append(conditions, "block_number >= "+param(query.FromBlock))
+	}
+	if query.ToBlock > 0 {
+		conditions = append(conditions, "block_number <= "+param(query.ToBlock))
+	}
+	// Events stored before log indexes and block hashes were recorded sort first. The
+	// expressions match erc20_events_position_idx, so pages are read in index order.
+	position := "block_number, COALESCE(log_index, 0), COALESCE(block_hash, ''), tx_hash"
+	if after != nil {
+		conditions = append(conditions, fmt.Sprintf("(%s) > (%s, %s, %s, %s)", position,
+			param(after.blockNumber), param(after.logIndex), param(after.blockHash), param(after.txHash)))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	limit := query.limit()
+	rows, err := db.conn.Query(fmt.Sprintf(`
+		SELECT COALESCE(contract_address, ''), block_number, COALESCE(block_hash, ''), tx_hash, COALESCE(log_index, 0),
+			event_type, from_address, to_address, owner_address, spender_address, value
+		FROM erc20_events %s ORDER BY %s LIMIT %d
+	`, where, position, limit+1), args...)
+	if err != nil {
+		return EventPage{}, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var value sql.NullString
+		err := rows.Scan(&e.ContractAddress, &e.BlockNumber, &e.BlockHash, &e.TxHash, &e.LogIndex,
+			&e.EventType, &e.From, &e.To, &e.Owner, &e.Spender, &value)
+		if err != nil {
+			return EventPage{}, err
+		}
+		if value.Valid {
+			e.Value, _ = new(big.Int).SetString(value.String, 10)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return EventPage{}, err
+	}
+	return newEventPage(events, limit), nil
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"math"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testQueries runs the same event queries against a store, so every Interface
+// implementation filters and pages alike
+func testQueries(t *testing.T, store Interface) {
+	usdc := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	dai := "0x6B175474E89094C44Da98b954EedeAC495271d0F"
+	alice := "0x1234567890abcdef1234567890abcdef12345678"
+	bob := "0x1234567890abcdef1234567890abcdef12345679"
+	carol := "0x1234567890abcdef1234567890abcdef1234567a"
+	zero := zeroAddress
+
+	event := func(contractAddress string, blockNumber uint64, txHash string, logIndex uint, eventType string, from, to, owner, spender *string, value int64) Event {
+		return Event{
+			ContractAddress: contractAddress,
+			BlockNumber:     blockNumber,
+			BlockHash:       testBlockHash(blockNumber),
+			TxHash:          txHash,
+			LogIndex:        logIndex,
+			EventType:       eventType,
+			From:            from,
+			To:              to,
+			Owner:           owner,
+			Spender:         spender,
+			Value:           big.NewInt(value),
+		}
+	}
+	transferFrom := testBlockHash(2011)
+	assert.Nil(t, store.SaveEvents([]Event{
+		event(usdc, 10, testBlockHash(2010), 0, "Mint", &zero, &alice, nil, nil, 100),
+		event(usdc, 10, testBlockHash(2010), 1, "Approval", nil, nil, &alice, &bob, 50),
+		event(usdc, 11, transferFrom, 0, "Transfer", &alice, &bob, nil, &bob, 30),
+		event(usdc, 11, transferFrom, 2, "Transfer", &bob, &carol, nil, nil, 10),
+		event(dai, 12, testBlockHash(2012), 0, "Transfer", &carol, &alice, nil, nil, 5),
+		event(usdc, 13, testBlockHash(2013), 0, "Burn", &bob, &zero, nil, nil, 1),
+	}))
+
+	// positions pages through a query and returns the block and log index of every event
+	positions := func(query EventQuery) [][2]uint64 {
+		var positions [][2]uint64
+		for pages := 0; pages < 10; pages++ {
+			page, err := store.QueryEvents(query)
+			assert.Nil(t, err, "Error should be nil")
+			if query.Limit > 0 {
+				assert.LessOrEqual(t, len(page.Events), query.Limit)
+			}
+			for _, e := range page.Events {
+				positions = append(positions, [2]uint64{e.BlockNumber, uint64(e.LogIndex)})
+			}
+			if page.NextCursor == "" {
+				return positions
+			}
+			query.Cursor = page.NextCursor
+		}
+		t.Fatal("Query did not end")
+		return nil
+	}
+
+	all := [][2]uint64{{10, 0}, {10, 1}, {11, 0}, {11, 2}, {12, 0}, {13, 0}}
+	assert.Equal(t, all, positions(EventQuery{}))
+	assert.Equal(t, all, positions(EventQuery{Limit: 1}), "Pages ending inside a block should continue with its next event")
+	assert.Equal(t, all, positions(EventQuery{Limit: 2}), "Pages should continue after their cursor")
+	assert.Equal(t, all, positions(EventQuery{Limit: 4}))
+
+	assert.Equal(t, [][2]uint64{{10, 0}, {10, 1}, {11, 0}, {12, 0}}, positions(EventQuery{Address: alice, Limit: 1}),
+		"Addresses should match senders, recipients and owners")
+	assert.Equal(t, [][2]uint64{{10, 1}, {11, 0}, {11, 2}, {13, 0}}, positions(EventQuery{Address: bob, ContractAddress: usdc}),
+		"Addresses should match spenders")
+	assert.Equal(t, [][2]uint64{{11, 0}, {11, 2}}, positions(EventQuery{TxHash: transferFrom}))
+	assert.Equal(t, [][2]uint64{{11, 0}, {11, 2}, {12, 0}}, positions(EventQuery{EventTypes: []string{"Transfer"}, Limit: 2}))
+	assert.Equal(t, [][2]uint64{{10, 0}, {11, 0}, {11, 2}, {13, 0}}, positions(EventQuery{ContractAddress: usdc, EventTypes: []string{"Mint", "Transfer", "Burn"}}))
+	assert.Equal(t, [][2]uint64{{11, 0}, {11, 2}, {12, 0}}, positions(EventQuery{FromBlock: 11, ToBlock: 12}))
+	assert.Equal(t, [][2]uint64{{12, 0}, {13, 0}}, positions(EventQuery{FromBlock: 12}))
+	assert.Equal(t, [][2]uint64{{11, 0}, {12, 0}}, positions(EventQuery{Address: alice, EventTypes: []string{"Transfer"}, FromBlock: 11}))
+	assert.Empty(t, positions(EventQuery{Address: alice, ContractAddress: dai, ToBlock: 11}))
+
+	page, err := store.QueryEvents(EventQuery{Limit: 1})
+	assert.Nil(t, err, "Error should be nil")
+	if assert.Len(t, page.Events, 1) {
+		mint := page.Events[0]
+		assert.Equal(t, usdc, mint.ContractAddress)
+		assert.Equal(t, testBlockHash(10), mint.BlockHash)
+		assert.Equal(t, testBlockHash(2010), mint.TxHash)
+		assert.Equal(t, "Mint", mint.EventType)
+		assert.Equal(t, zero, *mint.From)
+		assert.Equal(t, alice, *mint.To)
+		assert.Nil(t, mint.Owner)
+		assert.Equal(t, "100", mint.Value.String())
+	}
+
+	// Cursors stay valid across rollbacks, continuing after the position they mark
+	assert.Nil(t, store.Rollback(12))
+	page, err = store.QueryEvents(EventQuery{Cursor: page.NextCursor})
+	assert.Nil(t, err, "Error should be nil")
+	assert.Len(t, page.Events, 3)
+	assert.Empty(t, page.NextCursor)
+
+	_, err = store.QueryEvents(EventQuery{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	assert.Nil(t, store.Close())
+}
+
+// testPostgresDB returns a freshly migrated Postgres database, skipping the test unless
+// TEST_POSTGRES_CONN_STR points at a disposable database
+func testPostgresDB(t *testing.T) *DB {
+	connStr := os.Getenv("TEST_POSTGRES_CONN_STR")
+	if connStr == "" {
+		t.Skip("TEST_POSTGRES_CONN_STR is not set")
+	}
+	db, err := Open(connStr)
+	if err != nil {
+		t.Fatalf("Failed to connect to the Postgres database: %v", err)
+	}
+	if _, err := db.MigrateDown(math.MaxInt); err != nil {
+		t.Fatalf("Failed to reset the Postgres database: %v", err)
+	}
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatalf("Failed to migrate the Postgres database: %v", err)
+	}
+	return db
+}
+
+func TestQueryEvents(t *testing.T) {
+	t.Run("DB", func(t *testing.T) { testQueries(t, InitTestDB()) })
+	t.Run("Postgres", func(t *testing.T) { testQueries(t, testPostgresDB(t)) })
+	t.Run("MemoryDB", func(t *testing.T) { testQueries(t, NewMemoryDB()) })
+	t.Run("BoltDB", func(t *testing.T) { testQueries(t, testBoltDB(t)) })
+	t.Run("BatchWriter", func(t *testing.T) { testQueries(t, NewBatchWriter(NewMemoryDB(), 100, time.Hour)) })
+}
+
+func TestEventQuery_Limit(t *testing.T) {
+	assert.Equal(t, DefaultEventLimit, EventQuery{}.limit())
+	assert.Equal(t, 10, EventQuery{Limit: 10}.limit())
+	assert.Equal(t, MaxEventLimit, EventQuery{Limit: MaxEventLimit + 1}.limit())
+}
@@ -0,0 +1,38 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+
+	// import sqlite driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteScheme prefixes the connection strings of SQLite databases
+const sqliteScheme = "sqlite://"
+
+// openSQLite opens the SQLite database at the given path, or an in-memory database for
+// :memory:. Options of the driver, such as ?_journal_mode=WAL, may follow the path.
+func openSQLite(path string) (*DB, error) {
+	// Wait for other processes, such as the migrate command, to release their locks
+	if !strings.Contains(path, "_busy_timeout") {
+		separator := "?"
+		if strings.Contains(path, "?") {
+			separator = "&"
+		}
+		path += separator + "_busy_timeout=5000"
+	}
+
+	conn, err := sql.Open(sqlite.Name, path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, and an in-memory database only exists for the
+	// connection that created it
+	conn.SetMaxOpenConns(1)
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &DB{conn: conn, dialect: sqlite}, nil
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+)
+
+// updateSupply records the total supply of a token after a mint or burn in the given block
+func updateSupply(tx *sql.Tx, contractAddress string, blockNumber uint64, eventType string, amount *big.Int) error {
+	supply, err := scanBigInt(tx.QueryRow(`
+		SELECT total_supply FROM token_supply WHERE contract_address = $1
+		ORDER BY block_number DESC LIMIT 1
+	`, contractAddress))
+	if err != nil {
+		return err
+	}
+
+	switch eventType {
+	case "Mint":
+		supply.Add(supply, amount)
+	case "Burn":
+		supply.Sub(supply, amount)
+	default:
+		return fmt.Errorf("unexpected supply change %q", eventType)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO token_supply (contract_address, block_number, total_supply) VALUES ($1, $2, $3)
+		ON CONFLICT (contract_address, block_number) DO UPDATE SET total_supply = excluded.total_supply
+	`, contractAddress, blockNumber, supply.String())
+	return err
+}
+
+// GetTotalSupply returns the latest total supply of a token and the block it changed in,
+// or false if no mint or burn has been indexed
+func (db *DB) GetTotalSupply(contractAddress string) (uint64, *big.Int, bool, error) {
+	var blockNumber uint64
+	var value string
+	err := db.conn.QueryRow(`
+		SELECT block_number, total_supply FROM token_supply WHERE contract_address = $1
+		ORDER BY block_number DESC LIMIT 1
+	`, contractAddress).Scan(&blockNumber, &value)
+	if err == sql.ErrNoRows {
+		return 0, nil, false, nil
+	}
+	if err != nil {
+		return 0, nil, false, err
+	}
+	supply, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return 0, nil, false, fmt.Errorf("invalid total supply %q", value)
+	}
+	return blockNumber, supply, true, nil
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTotalSupply(t *testing.T) {
+	db := InitTestDB()
+
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	alice := "0x1234567890abcdef1234567890abcdef12345678"
+	bob := "0x1234567890abcdef1234567890abcdef12345679"
+	var logIndex uint
+	transfer := func(blockNumber uint64, eventType, from, to string, value int64) Event {
+		logIndex++
+		return Event{
+			ContractAddress: contractAddress,
+			BlockNumber:     blockNumber,
+			BlockHash:       testBlockHash(blockNumber),
+			TxHash:          "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+			LogIndex:        logIndex,
+			EventType:       eventType,
+			From:            &from,
+			To:              &to,
+			Value:           big.NewInt(value),
+		}
+	}
+
+	_, _, ok, err := db.GetTotalSupply(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.False(t, ok, "No supply should be known before the first mint")
+
+	assert.Nil(t, db.SaveEvent(transfer(10, "Mint", zeroAddress, alice, 100)))
+	assert.Nil(t, db.SaveEvent(transfer(10, "Mint", zeroAddress, bob, 50)))
+	assert.Nil(t, db.SaveEvent(transfer(11, "Transfer", alice, bob, 20)))
+	assert.Nil(t, db.SaveEvent(transfer(12, "Burn", bob, zeroAddress, 30)))
+
+	block, supply, ok, err := db.GetTotalSupply(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(12), block)
+	assert.Equal(t, "120", supply.String())
+
+	balance, err := db.GetBalance(contractAddress, bob)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "40", balance.String(), "Mints and burns should update balances like transfers")
+
+	assert.Nil(t, db.Rollback(12))
+	block, supply, _, err = db.GetTotalSupply(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, uint64(10), block)
+	assert.Equal(t, "150", supply.String(), "Rolled back burns should restore the supply")
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"database/sql"
+)
+
+// SaveToken stores the metadata of a token, replacing any previous metadata
+func (db *DB) SaveToken(token Token) error {
+	var decimals *int
+	if token.Decimals != nil {
+		d := int(*token.Decimals)
+		decimals = &d
+	}
+	_, err := db.conn.Exec(`
+		INSERT INTO tokens (contract_address, name, symbol, decimals) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (contract_address) DO UPDATE
+		SET name = excluded.name, symbol = excluded.symbol, decimals = excluded.decimals, updated_at = CURRENT_TIMESTAMP
+	`, token.ContractAddress, token.Name, token.Symbol, decimals)
+	return err
+}
+
+// GetToken returns the stored metadata of a token, or false if it has not been fetched
+func (db *DB) GetToken(contractAddress string) (Token, bool, error) {
+	token := Token{ContractAddress: contractAddress}
+	var name, symbol sql.NullString
+	var decimals sql.NullInt64
+	err := db.conn.QueryRow(`
+		SELECT name, symbol, decimals, updated_at FROM tokens WHERE contract_address = $1
+	`, contractAddress).Scan(&name, &symbol, &decimals, &token.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return Token{}, false, nil
+	}
+	if err != nil {
+		return Token{}, false, err
+	}
+
+	if name.Valid {
+		token.Name = &name.String
+	}
+	if symbol.Valid {
+		token.Symbol = &symbol.String
+	}
+	if decimals.Valid {
+		d := uint8(decimals.Int64)
+		token.Decimals = &d
+	}
+	return token, true, nil
+}
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokens(t *testing.T) {
+	db := InitTestDB()
+
+	contractAddress := "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	_, ok, err := db.GetToken(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.False(t, ok)
+
+	name, symbol, decimals := "USD Coin", "USDC", uint8(6)
+	assert.Nil(t, db.SaveToken(Token{ContractAddress: contractAddress, Name: &name, Symbol: &symbol, Decimals: &decimals}))
+
+	token, ok, err := db.GetToken(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.True(t, ok)
+	assert.Equal(t, "USD Coin", *token.Name)
+	assert.Equal(t, "USDC", *token.Symbol)
+	assert.Equal(t, uint8(6), *token.Decimals)
+
+	// A refresh replaces the metadata, including fields the contract stopped returning
+	assert.Nil(t, db.SaveToken(Token{ContractAddress: contractAddress, Name: &name}))
+	token, _, err = db.GetToken(contractAddress)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Nil(t, token.Symbol)
+	assert.Nil(t, token.Decimals)
+}
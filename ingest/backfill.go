@@ -0,0 +1,56 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// DefaultBatchSize is the number of blocks requested per FilterLogs call during backfill.
+const DefaultBatchSize uint64 = 2000
+
+// Client is the subset of the Ethereum client used to fetch contract logs.
+type Client interface {
+	ethereum.LogFilterer
+	ethereum.BlockNumberReader
+}
+
+// Backfill pages through FilterLogs for the blocks from..to (inclusive) and sends each log to out in order.
+func Backfill(ctx context.Context, client Client, query ethereum.FilterQuery, from, to, batchSize uint64, out chan<- types.Log) error {
+	if batchSize == 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	for start := from; start <= to; start += batchSize {
+		end := start + batchSize - 1
+		if end > to || end < start {
+			end = to
+		}
+
+		page := query
+		page.FromBlock = new(big.Int).SetUint64(start)
+		page.ToBlock = new(big.Int).SetUint64(end)
+
+		logs, err := client.FilterLogs(ctx, page)
+		if err != nil {
+			return fmt.Errorf("failed to filter logs for blocks %d-%d: %v", start, end, err)
+		}
+
+		for _, vLog := range logs {
+			select {
+			case out <- vLog:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if end == to {
+			break
+		}
+	}
+
+	return nil
+}
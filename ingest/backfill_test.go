@@ -0,0 +1,139 @@
+package ingest
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClient is an in-memory Client serving logs from a fixed list
+type fakeClient struct {
+	mu     sync.Mutex
+	logs   []types.Log
+	head   uint64
+	ranges [][2]uint64
+	live   chan<- types.Log
+	subErr chan error
+	err    error
+	// callErr fails contract calls only
+	callErr error
+}
+
+func (c *fakeClient) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	from, to := q.FromBlock.Uint64(), q.ToBlock.Uint64()
+	c.ranges = append(c.ranges, [2]uint64{from, to})
+
+	var result []types.Log
+	for _, l := range c.logs {
+		if l.BlockNumber >= from && l.BlockNumber <= to {
+			result = append(result, l)
+		}
+	}
+	return result, nil
+}
+
+func (c *fakeClient) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.live = ch
+	subErr := c.subErr
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		select {
+		case <-quit:
+			return nil
+		case err := <-subErr:
+			return err
+		}
+	}), nil
+}
+
+func (c *fakeClient) BlockNumber(ctx context.Context) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.head, c.err
+}
+
+func (c *fakeClient) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	return nil, ethereum.NotFound
+}
+
+func (c *fakeClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &types.Header{Number: new(big.Int).SetUint64(c.head)}, nil
+}
+
+func (c *fakeClient) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	return nil, false, ethereum.NotFound
+}
+
+func (c *fakeClient) TransactionSender(ctx context.Context, tx *types.Transaction, block common.Hash, index uint) (common.Address, error) {
+	return common.Address{}, ethereum.NotFound
+}
+
+func (c *fakeClient) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return nil, c.err
+}
+
+func (c *fakeClient) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if c.callErr != nil {
+		return nil, c.callErr
+	}
+	return nil, c.err
+}
+
+// testLog creates a log for the given block and log index
+func testLog(block uint64, index uint) types.Log {
+	return types.Log{
+		Address:     common.HexToAddress("0x1234567890abcdef1234567890abcdef12345678"),
+		BlockNumber: block,
+		Index:       index,
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	client := &fakeClient{
+		logs: []types.Log{testLog(10, 0), testLog(12, 0), testLog(12, 1), testLog(15, 0), testLog(21, 0)},
+	}
+
+	out := make(chan types.Log, 10)
+	err := Backfill(context.Background(), client, ethereum.FilterQuery{}, 10, 20, 4, out)
+	assert.NoError(t, err)
+	close(out)
+
+	var blocks []uint64
+	for l := range out {
+		blocks = append(blocks, l.BlockNumber)
+	}
+
+	assert.Equal(t, []uint64{10, 12, 12, 15}, blocks, "Only logs in range should be sent, in order")
+	assert.Equal(t, [][2]uint64{{10, 13}, {14, 17}, {18, 20}}, client.ranges, "Range should be paged by batch size")
+}
+
+func TestBackfill_Cancelled(t *testing.T) {
+	client := &fakeClient{logs: []types.Log{testLog(1, 0), testLog(2, 0)}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := Backfill(ctx, client, ethereum.FilterQuery{}, 1, 2, 10, make(chan types.Log))
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,437 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultHealthCheckInterval is how often endpoints are health-checked when no interval is configured.
+const DefaultHealthCheckInterval = 30 * time.Second
+
+// healthCheckTimeout bounds a single endpoint health check.
+const healthCheckTimeout = 10 * time.Second
+
+// rpcLimitExceeded is the JSON-RPC error code of providers rejecting requests over their rate limit.
+const rpcLimitExceeded = -32005
+
+var (
+	errNoSubscriptionEndpoint = errors.New("no endpoint supports log subscriptions")
+	errEndpointDemoted        = errors.New("endpoint demoted by health check")
+	errNoHealthyEndpoint      = errors.New("no healthy RPC endpoint")
+)
+
+// Backend is an RPC provider connection usable by Failover.
+type Backend interface {
+	Client
+	HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+	TransactionSender(ctx context.Context, tx *types.Transaction, block common.Hash, index uint) (common.Address, error)
+	CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error)
+	CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// Endpoint is an RPC provider. Endpoints with a lower priority value are preferred.
+type Endpoint struct {
+	URL      string
+	Priority int
+	Backend  Backend
+
+	healthy bool
+	head    uint64
+	demoted chan struct{}
+}
+
+// Failover spreads calls over several endpoints. Calls go to the preferred healthy
+// endpoint and fall through to the next one on error. Endpoints that error or lag
+// more than MaxLag blocks behind the highest head are demoted by HealthCheck.
+type Failover struct {
+	Logger logrus.FieldLogger
+	// MaxLag is how many blocks an endpoint may trail the highest head before it is demoted.
+	MaxLag uint64
+	// CrossCheck compares the FilterLogs results of the two preferred endpoints.
+	CrossCheck bool
+
+	mu        sync.RWMutex
+	endpoints []*Endpoint
+	restored  chan struct{}
+}
+
+// NewFailover creates a Failover over the given endpoints, all initially healthy.
+func NewFailover(endpoints []*Endpoint, logger logrus.FieldLogger) *Failover {
+	sorted := append([]*Endpoint(nil), endpoints...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+	for _, e := range sorted {
+		e.healthy = true
+		e.demoted = make(chan struct{})
+	}
+	return &Failover{Logger: logger, endpoints: sorted}
+}
+
+// HealthCheck queries the head of every endpoint and demotes those that fail or lag.
+// It returns an error when no endpoint is left healthy.
+func (f *Failover) HealthCheck(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	heads := make([]uint64, len(f.endpoints))
+	errs := make([]error, len(f.endpoints))
+	var wg sync.WaitGroup
+	for i, e := range f.endpoints {
+		wg.Add(1)
+		go func(i int, e *Endpoint) {
+			defer wg.Done()
+			heads[i], errs[i] = e.Backend.BlockNumber(ctx)
+		}(i, e)
+	}
+	wg.Wait()
+
+	var maxHead uint64
+	for i := range f.endpoints {
+		if errs[i] == nil && heads[i] > maxHead {
+			maxHead = heads[i]
+		}
+	}
+
+	healthy := 0
+	for i, e := range f.endpoints {
+		switch {
+		case errs[i] != nil:
+			f.demote(e, errs[i])
+		case heads[i]+f.MaxLag < maxHead:
+			f.demote(e, fmt.Errorf("head %d is %d blocks behind", heads[i], maxHead-heads[i]))
+		default:
+			f.restore(e, heads[i])
+			healthy++
+		}
+	}
+	if healthy == 0 {
+		return errNoHealthyEndpoint
+	}
+	return nil
+}
+
+// Run health-checks the endpoints every interval until the context is cancelled.
+func (f *Failover) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Unhealthy endpoints are logged as they are demoted
+			_ = f.HealthCheck(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// demote marks an endpoint unhealthy and ends the subscriptions opened on it.
+func (f *Failover) demote(e *Endpoint, reason error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !e.healthy {
+		return
+	}
+	e.healthy = false
+	close(e.demoted)
+	f.Logger.Warnf("RPC endpoint %s is unhealthy: %v", e.URL, reason)
+}
+
+// restore marks an endpoint healthy again.
+func (f *Failover) restore(e *Endpoint, head uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e.head = head
+	if e.healthy {
+		return
+	}
+	e.healthy = true
+	e.demoted = make(chan struct{})
+	if f.restored != nil && !RequiresPolling(e.URL) {
+		close(f.restored)
+		f.restored = nil
+	}
+	f.Logger.Infof("RPC endpoint %s is healthy again at block %d", e.URL, head)
+}
+
+// candidates returns the healthy endpoints in priority order, followed by the unhealthy
+// ones as a last resort.
+func (f *Failover) candidates() []*Endpoint {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var healthy, unhealthy []*Endpoint
+	for _, e := range f.endpoints {
+		if e.healthy {
+			healthy = append(healthy, e)
+		} else {
+			unhealthy = append(unhealthy, e)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// unavailable reports whether an error means the endpoint cannot serve requests, rather
+// than a JSON-RPC error about the request itself such as a reverted call or pruned state.
+func unavailable(err error) bool {
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr.ErrorCode() == rpcLimitExceeded
+	}
+	return !errors.Is(err, ethereum.NotFound)
+}
+
+// healthy returns the healthy endpoints in priority order.
+func (f *Failover) healthy() []*Endpoint {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var healthy []*Endpoint
+	for _, e := range f.endpoints {
+		if e.healthy {
+			healthy = append(healthy, e)
+		}
+	}
+	return healthy
+}
+
+// try calls fn on each candidate endpoint until one succeeds, demoting those that are
+// unavailable. Other errors are returned to the caller unchanged.
+func try[T any](f *Failover, ctx context.Context, fn func(Backend) (T, error)) (T, error) {
+	var result T
+	err := errors.New("no RPC endpoints configured")
+	for _, e := range f.candidates() {
+		result, err = fn(e.Backend)
+		if err == nil || ctx.Err() != nil || !unavailable(err) {
+			return result, err
+		}
+		f.demote(e, err)
+	}
+	return result, err
+}
+
+// BlockNumber returns the head block number of the preferred endpoint. With CrossCheck
+// set, it is the lower head of the two preferred healthy endpoints, so the ranges up to
+// it can be fetched from both of them.
+func (f *Failover) BlockNumber(ctx context.Context) (uint64, error) {
+	healthy := f.healthy()
+	if !f.CrossCheck || len(healthy) < 2 {
+		return try(f, ctx, func(b Backend) (uint64, error) {
+			return b.BlockNumber(ctx)
+		})
+	}
+
+	heads, err := each(f, ctx, healthy[:2], func(b Backend) (uint64, error) {
+		return b.BlockNumber(ctx)
+	})
+	if errors.Is(err, errEndpointDemoted) {
+		return f.BlockNumber(ctx)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return min(heads[0], heads[1]), nil
+}
+
+// HeaderByHash returns a block header from the preferred endpoint.
+func (f *Failover) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	return try(f, ctx, func(b Backend) (*types.Header, error) {
+		return b.HeaderByHash(ctx, hash)
+	})
+}
+
+// HeaderByNumber returns a block header from the preferred endpoint.
+func (f *Failover) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return try(f, ctx, func(b Backend) (*types.Header, error) {
+		return b.HeaderByNumber(ctx, number)
+	})
+}
+
+// TransactionByHash returns a transaction from the preferred endpoint.
+func (f *Failover) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	type result struct {
+		tx      *types.Transaction
+		pending bool
+	}
+	r, err := try(f, ctx, func(b Backend) (result, error) {
+		tx, pending, err := b.TransactionByHash(ctx, hash)
+		return result{tx, pending}, err
+	})
+	return r.tx, r.pending, err
+}
+
+// TransactionSender returns the sender of a mined transaction from the preferred endpoint.
+func (f *Failover) TransactionSender(ctx context.Context, tx *types.Transaction, block common.Hash, index uint) (common.Address, error) {
+	return try(f, ctx, func(b Backend) (common.Address, error) {
+		return b.TransactionSender(ctx, tx, block, index)
+	})
+}
+
+// CodeAt returns the code of a contract from the preferred endpoint.
+func (f *Failover) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return try(f, ctx, func(b Backend) ([]byte, error) {
+		return b.CodeAt(ctx, contract, blockNumber)
+	})
+}
+
+// CallContract executes a contract call on the preferred endpoint.
+func (f *Failover) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return try(f, ctx, func(b Backend) ([]byte, error) {
+		return b.CallContract(ctx, call, blockNumber)
+	})
+}
+
+// FilterLogs returns the logs matching the query from the preferred endpoint. With
+// CrossCheck set, the two preferred healthy endpoints are queried and must agree. On a
+// disagreement a third endpoint breaks the tie, or without one the endpoint of lower
+// priority is taken to be wrong, and the dissenting endpoint is demoted before the
+// query is retried on the remaining ones.
+func (f *Failover) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	healthy := f.healthy()
+	if !f.CrossCheck || len(healthy) < 2 {
+		return try(f, ctx, func(b Backend) ([]types.Log, error) {
+			return b.FilterLogs(ctx, q)
+		})
+	}
+
+	results, err := each(f, ctx, healthy[:2], func(b Backend) ([]types.Log, error) {
+		return b.FilterLogs(ctx, q)
+	})
+	if errors.Is(err, errEndpointDemoted) {
+		return f.FilterLogs(ctx, q)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := compareLogs(results[0], results[1]); err != nil {
+		dissenter := healthy[1]
+		if len(healthy) > 2 {
+			tie, tieErr := healthy[2].Backend.FilterLogs(ctx, q)
+			if tieErr == nil && compareLogs(results[1], tie) == nil {
+				dissenter = healthy[0]
+			}
+		}
+		f.demote(dissenter, fmt.Errorf("disagrees on logs for blocks %v-%v: %v", q.FromBlock, q.ToBlock, err))
+		return f.FilterLogs(ctx, q)
+	}
+	return results[0], nil
+}
+
+// each calls fn on the given endpoints concurrently. An endpoint that is unavailable is
+// demoted and errEndpointDemoted returned, so the call can be retried without it.
+func each[T any](f *Failover, ctx context.Context, endpoints []*Endpoint, fn func(Backend) (T, error)) ([]T, error) {
+	results := make([]T, len(endpoints))
+	errs := make([]error, len(endpoints))
+	var wg sync.WaitGroup
+	for i, e := range endpoints {
+		wg.Add(1)
+		go func(i int, e *Endpoint) {
+			defer wg.Done()
+			results[i], errs[i] = fn(e.Backend)
+		}(i, e)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if ctx.Err() == nil && unavailable(err) {
+			f.demote(endpoints[i], err)
+			return nil, errEndpointDemoted
+		}
+		return nil, err
+	}
+	return results, nil
+}
+
+// compareLogs checks that two providers returned the same logs.
+func compareLogs(a, b []types.Log) error {
+	if len(a) != len(b) {
+		return fmt.Errorf("%d logs against %d", len(a), len(b))
+	}
+	for i := range a {
+		if !sameLog(a[i], b[i]) {
+			return fmt.Errorf("log %d differs: block %s tx %s index %d against block %s tx %s index %d",
+				i, a[i].BlockHash.Hex(), a[i].TxHash.Hex(), a[i].Index, b[i].BlockHash.Hex(), b[i].TxHash.Hex(), b[i].Index)
+		}
+	}
+	return nil
+}
+
+// sameLog reports whether two logs have the same position and content.
+func sameLog(a, b types.Log) bool {
+	return a.Address == b.Address && slices.Equal(a.Topics, b.Topics) && bytes.Equal(a.Data, b.Data) &&
+		a.BlockNumber == b.BlockNumber && a.BlockHash == b.BlockHash && a.TxHash == b.TxHash &&
+		a.TxIndex == b.TxIndex && a.Index == b.Index && a.Removed == b.Removed
+}
+
+// SubscribeFilterLogs subscribes on the preferred healthy endpoint that supports
+// subscriptions. The subscription fails once that endpoint is demoted, so the stream can
+// be re-established on a healthier one. Without such an endpoint it fails with
+// errNoSubscriptionEndpoint, so the logs can be polled instead.
+func (f *Failover) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	for _, e := range f.candidates() {
+		if RequiresPolling(e.URL) {
+			continue
+		}
+
+		f.mu.RLock()
+		healthy, demoted := e.healthy, e.demoted
+		f.mu.RUnlock()
+		// The demoted channel of an unhealthy endpoint is already closed
+		if !healthy {
+			continue
+		}
+
+		inner, err := e.Backend.SubscribeFilterLogs(ctx, q, ch)
+		if err != nil {
+			f.demote(e, err)
+			continue
+		}
+
+		return event.NewSubscription(func(quit <-chan struct{}) error {
+			defer inner.Unsubscribe()
+			select {
+			case err := <-inner.Err():
+				return err
+			case <-demoted:
+				return errEndpointDemoted
+			case <-quit:
+				return nil
+			}
+		}), nil
+	}
+	return nil, errNoSubscriptionEndpoint
+}
+
+// subscriptionsRestored returns a channel that is closed once an endpoint supporting
+// subscriptions is restored by a health check.
+func (f *Failover) subscriptionsRestored() <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.restored == nil {
+		f.restored = make(chan struct{})
+	}
+	return f.restored
+}
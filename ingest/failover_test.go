@@ -0,0 +1,176 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailover_Priority(t *testing.T) {
+	primary := &fakeClient{head: 100}
+	backup := &fakeClient{head: 100}
+	failover := NewFailover([]*Endpoint{
+		{URL: "wss://backup", Priority: 2, Backend: backup},
+		{URL: "wss://primary", Priority: 1, Backend: primary},
+	}, logrus.New())
+
+	primary.head = 101
+	head, err := failover.BlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(101), head, "The endpoint with the lowest priority value should be preferred")
+
+	// Errors fall through to the next endpoint and demote the failing one
+	primary.err = errors.New("503 Service Unavailable")
+	head, err = failover.BlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), head)
+	assert.Equal(t, "wss://backup", failover.candidates()[0].URL)
+}
+
+// revertError is the JSON-RPC error of a reverted call
+type revertError struct{}
+
+func (revertError) Error() string  { return "execution reverted" }
+func (revertError) ErrorCode() int { return 3 }
+
+func TestFailover_ExecutionErrors(t *testing.T) {
+	primary := &fakeClient{head: 100, callErr: revertError{}}
+	backup := &fakeClient{head: 100}
+	failover := NewFailover([]*Endpoint{
+		{URL: "wss://primary", Priority: 1, Backend: primary},
+		{URL: "wss://backup", Priority: 2, Backend: backup},
+	}, logrus.New())
+
+	sub, err := failover.SubscribeFilterLogs(context.Background(), ethereum.FilterQuery{}, make(chan types.Log))
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	_, err = failover.CallContract(context.Background(), ethereum.CallMsg{}, nil)
+	assert.Equal(t, revertError{}, err, "Reverts should be returned to the caller unchanged")
+	assert.Equal(t, "wss://primary", failover.candidates()[0].URL, "Reverts should not demote the endpoint")
+	select {
+	case err := <-sub.Err():
+		t.Fatalf("Subscription ended after a revert: %v", err)
+	default:
+	}
+}
+
+func TestFailover_HealthCheck(t *testing.T) {
+	primary := &fakeClient{head: 90}
+	backup := &fakeClient{head: 100}
+	failover := NewFailover([]*Endpoint{
+		{URL: "wss://primary", Priority: 1, Backend: primary},
+		{URL: "wss://backup", Priority: 2, Backend: backup},
+	}, logrus.New())
+	failover.MaxLag = 5
+
+	assert.NoError(t, failover.HealthCheck(context.Background()))
+	assert.Equal(t, "wss://backup", failover.candidates()[0].URL, "Lagging endpoint should be demoted")
+
+	primary.head = 98
+	assert.NoError(t, failover.HealthCheck(context.Background()))
+	assert.Equal(t, "wss://primary", failover.candidates()[0].URL, "Endpoint should be restored once caught up")
+
+	primary.err = errors.New("connection refused")
+	backup.err = errors.New("connection refused")
+	assert.ErrorIs(t, failover.HealthCheck(context.Background()), errNoHealthyEndpoint, "An error should be returned once every endpoint is down")
+}
+
+func TestFailover_DemotionEndsSubscription(t *testing.T) {
+	primary := &fakeClient{head: 90}
+	backup := &fakeClient{head: 100}
+	failover := NewFailover([]*Endpoint{
+		{URL: "wss://primary", Priority: 1, Backend: primary},
+		{URL: "wss://backup", Priority: 2, Backend: backup},
+	}, logrus.New())
+
+	sub, err := failover.SubscribeFilterLogs(context.Background(), ethereum.FilterQuery{}, make(chan types.Log))
+	assert.NoError(t, err)
+	assert.NotNil(t, primary.live, "Subscription should be opened on the preferred endpoint")
+
+	assert.NoError(t, failover.HealthCheck(context.Background()))
+	assert.ErrorIs(t, <-sub.Err(), errEndpointDemoted)
+}
+
+func TestFailover_SkipsHTTPForSubscriptions(t *testing.T) {
+	primary := &fakeClient{}
+	backup := &fakeClient{}
+	failover := NewFailover([]*Endpoint{
+		{URL: "https://primary", Priority: 1, Backend: primary},
+		{URL: "wss://backup", Priority: 2, Backend: backup},
+	}, logrus.New())
+
+	sub, err := failover.SubscribeFilterLogs(context.Background(), ethereum.FilterQuery{}, make(chan types.Log))
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+	assert.Nil(t, primary.live)
+	assert.NotNil(t, backup.live)
+}
+
+func TestFailover_CrossCheck(t *testing.T) {
+	logs := []types.Log{testLog(5, 0), testLog(6, 0)}
+	primary := &fakeClient{logs: logs}
+	backup := &fakeClient{logs: logs}
+	failover := NewFailover([]*Endpoint{
+		{URL: "wss://primary", Priority: 1, Backend: primary},
+		{URL: "wss://backup", Priority: 2, Backend: backup},
+	}, logrus.New())
+	failover.CrossCheck = true
+
+	query := ethereum.FilterQuery{FromBlock: big.NewInt(0), ToBlock: big.NewInt(10)}
+	result, err := failover.FilterLogs(context.Background(), query)
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+
+	// Without a third endpoint, the endpoint of lower priority is assumed wrong
+	divergent := testLog(6, 0)
+	divergent.Data = []byte{0x01}
+	backup.logs = []types.Log{logs[0], divergent}
+	result, err = failover.FilterLogs(context.Background(), query)
+	assert.NoError(t, err)
+	assert.Equal(t, logs, result)
+	assert.Equal(t, []*Endpoint{failover.candidates()[0]}, failover.healthy(), "The dissenting endpoint should be demoted")
+	assert.Equal(t, "wss://primary", failover.candidates()[0].URL)
+}
+
+func TestFailover_CrossCheckTieBreak(t *testing.T) {
+	logs := []types.Log{testLog(5, 0), testLog(6, 0)}
+	divergent := testLog(6, 0)
+	divergent.Topics = []common.Hash{common.HexToHash("0x01")}
+	primary := &fakeClient{logs: []types.Log{logs[0], divergent}}
+	backup := &fakeClient{logs: logs}
+	third := &fakeClient{logs: logs}
+	failover := NewFailover([]*Endpoint{
+		{URL: "wss://primary", Priority: 1, Backend: primary},
+		{URL: "wss://backup", Priority: 2, Backend: backup},
+		{URL: "wss://third", Priority: 3, Backend: third},
+	}, logrus.New())
+	failover.CrossCheck = true
+
+	query := ethereum.FilterQuery{FromBlock: big.NewInt(0), ToBlock: big.NewInt(10)}
+	result, err := failover.FilterLogs(context.Background(), query)
+	assert.NoError(t, err)
+	assert.Equal(t, logs, result, "The logs the majority agrees on should be returned")
+	assert.Equal(t, "wss://backup", failover.candidates()[0].URL, "The minority endpoint should be demoted")
+}
+
+func TestFailover_CrossCheckHead(t *testing.T) {
+	primary := &fakeClient{head: 101}
+	backup := &fakeClient{head: 100}
+	failover := NewFailover([]*Endpoint{
+		{URL: "wss://primary", Priority: 1, Backend: primary},
+		{URL: "wss://backup", Priority: 2, Backend: backup},
+	}, logrus.New())
+	failover.CrossCheck = true
+
+	head, err := failover.BlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), head, "Ranges should end at the lower head of the cross-checked endpoints")
+}
@@ -0,0 +1,82 @@
+package ingest
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// DefaultPollInterval is how often the head is polled when no interval is configured.
+const DefaultPollInterval = 12 * time.Second
+
+// RequiresPolling reports whether the RPC URL uses a transport without subscription
+// support, so logs have to be polled with eth_getLogs instead.
+func RequiresPolling(rpcURL string) bool {
+	u, err := url.Parse(rpcURL)
+	if err != nil {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	return scheme == "http" || scheme == "https"
+}
+
+// Poll streams the logs matching query into out by tracking the head with
+// eth_blockNumber and fetching each new range with eth_getLogs. Polling starts at
+// query.FromBlock when it is set, otherwise at the block after the current head.
+// Removed logs are never reported, so reorgs are only detected through block hashes.
+func Poll(ctx context.Context, client Client, query ethereum.FilterQuery, batchSize uint64, interval time.Duration, out chan<- types.Log) (ethereum.Subscription, error) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	var next uint64
+	if query.FromBlock != nil {
+		next = query.FromBlock.Uint64()
+	} else {
+		head, err := client.BlockNumber(ctx)
+		if err != nil {
+			return nil, err
+		}
+		next = head + 1
+	}
+
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-quit:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			head, err := client.BlockNumber(ctx)
+			if err != nil {
+				return stopped(ctx, err)
+			}
+
+			if head >= next {
+				if err := Backfill(ctx, client, query, next, head, batchSize, out); err != nil {
+					return stopped(ctx, err)
+				}
+				next = head + 1
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}), nil
+}
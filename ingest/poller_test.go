@@ -0,0 +1,65 @@
+package ingest
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequiresPolling(t *testing.T) {
+	assert.True(t, RequiresPolling("http://localhost:8545"))
+	assert.True(t, RequiresPolling("HTTPS://mainnet.example.com/v3/key"))
+	assert.False(t, RequiresPolling("wss://mainnet.example.com/ws"))
+	assert.False(t, RequiresPolling("/var/run/geth.ipc"))
+}
+
+func TestPoll(t *testing.T) {
+	client := &fakeClient{
+		logs: []types.Log{testLog(3, 0), testLog(5, 0), testLog(7, 0), testLog(9, 0)},
+		head: 5,
+	}
+
+	out := make(chan types.Log)
+	query := ethereum.FilterQuery{FromBlock: big.NewInt(3)}
+	sub, err := Poll(context.Background(), client, query, 10, 10*time.Millisecond, out)
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	logs := receive(t, out, 2)
+	assert.Equal(t, uint64(3), logs[0].BlockNumber)
+	assert.Equal(t, uint64(5), logs[1].BlockNumber)
+
+	// The next poll only fetches the blocks after the previous head
+	client.mu.Lock()
+	client.head = 9
+	client.mu.Unlock()
+
+	logs = receive(t, out, 2)
+	assert.Equal(t, uint64(7), logs[0].BlockNumber)
+	assert.Equal(t, uint64(9), logs[1].BlockNumber)
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	assert.Equal(t, [2]uint64{6, 9}, client.ranges[len(client.ranges)-1])
+}
+
+func TestPoll_FromHead(t *testing.T) {
+	client := &fakeClient{logs: []types.Log{testLog(5, 0), testLog(6, 0)}, head: 5}
+
+	out := make(chan types.Log)
+	sub, err := Poll(context.Background(), client, ethereum.FilterQuery{}, 0, 10*time.Millisecond, out)
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	client.mu.Lock()
+	client.head = 6
+	client.mu.Unlock()
+
+	logs := receive(t, out, 1)
+	assert.Equal(t, uint64(6), logs[0].BlockNumber, "Polling should start after the current head")
+}
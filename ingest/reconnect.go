@@ -0,0 +1,234 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/sirupsen/logrus"
+)
+
+// Default backoff bounds between reconnection attempts.
+const (
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = time.Minute
+)
+
+var (
+	errSubscriptionClosed   = errors.New("subscription closed")
+	errSubscriptionRestored = errors.New("subscriptions available again")
+)
+
+// subscriptionRestorer is a client that can lose and regain subscription support, such
+// as a Failover whose websocket endpoints are all demoted.
+type subscriptionRestorer interface {
+	subscriptionsRestored() <-chan struct{}
+}
+
+// Dialer opens a new connection to the Ethereum client.
+type Dialer func(ctx context.Context) (Client, error)
+
+// Reconnector keeps a log stream alive across connection failures. When the stream
+// fails, it redials with exponential backoff and resumes from the last forwarded log,
+// backfilling the blocks missed during the outage.
+type Reconnector struct {
+	Dial   Dialer
+	Logger logrus.FieldLogger
+	// Query selects the logs to stream. FromBlock optionally sets the first block to backfill.
+	Query     ethereum.FilterQuery
+	BatchSize uint64
+	// Polling streams logs with Poll instead of Subscribe.
+	Polling      bool
+	PollInterval time.Duration
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+
+	reconnects atomic.Uint64
+}
+
+// Reconnects returns how many times the stream has been re-established.
+func (r *Reconnector) Reconnects() uint64 {
+	return r.reconnects.Load()
+}
+
+// cursor tracks the position of the last forwarded log.
+type cursor struct {
+	resumeFrom uint64
+	last       *types.Log
+	// replaying is set after a reconnect while the stream re-delivers logs that
+	// were already forwarded before the outage.
+	replaying bool
+}
+
+// seen reports whether a log is at or before the last forwarded log. A log of another
+// block at the same height replaces that block, so none of its logs have been seen.
+func (c *cursor) seen(vLog types.Log) bool {
+	if c.last == nil {
+		return false
+	}
+	if vLog.BlockNumber != c.last.BlockNumber {
+		return vLog.BlockNumber < c.last.BlockNumber
+	}
+	return vLog.BlockHash == c.last.BlockHash && vLog.Index <= c.last.Index
+}
+
+// rewind moves the cursor back before the block of a removed log, so the logs of the
+// replacement block are forwarded and fetched again on reconnect.
+func (c *cursor) rewind(vLog types.Log) {
+	if c.last != nil && vLog.BlockNumber <= c.last.BlockNumber {
+		c.last = nil
+		c.replaying = false
+	}
+	if vLog.BlockNumber < c.resumeFrom {
+		c.resumeFrom = vLog.BlockNumber
+	}
+}
+
+// Subscribe dials the client and streams the logs into out until unsubscribed.
+func (r *Reconnector) Subscribe(ctx context.Context, out chan<- types.Log) (ethereum.Subscription, error) {
+	client, err := r.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pos := &cursor{}
+	if r.Query.FromBlock != nil {
+		pos.resumeFrom = r.Query.FromBlock.Uint64()
+	} else {
+		head, err := client.BlockNumber(ctx)
+		if err != nil {
+			return nil, err
+		}
+		pos.resumeFrom = head + 1
+	}
+
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-quit:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		for {
+			err := r.stream(ctx, client, pos, out)
+			if ctx.Err() != nil {
+				return nil
+			}
+			r.Logger.Warnf("Log stream failed: %v. Reconnecting from block %d", err, pos.resumeFrom)
+
+			client = r.redial(ctx)
+			if client == nil {
+				return nil
+			}
+
+			pos.replaying = pos.last != nil
+			count := r.reconnects.Add(1)
+			r.Logger.Infof("Reconnected to the Ethereum client (reconnect #%d)", count)
+		}
+	}), nil
+}
+
+// stream opens a log stream from the cursor and forwards its logs until it fails.
+func (r *Reconnector) stream(ctx context.Context, client Client, pos *cursor, out chan<- types.Log) error {
+	query := r.Query
+	query.FromBlock = new(big.Int).SetUint64(pos.resumeFrom)
+
+	logs := make(chan types.Log)
+	var sub ethereum.Subscription
+	var err error
+	// restored ends a fallback to polling once subscriptions are available again
+	var restored <-chan struct{}
+	if r.Polling {
+		sub, err = Poll(ctx, client, query, r.BatchSize, r.PollInterval, logs)
+	} else {
+		if restorer, ok := client.(subscriptionRestorer); ok {
+			restored = restorer.subscriptionsRestored()
+		}
+		sub, err = Subscribe(ctx, client, query, r.BatchSize, logs)
+		if errors.Is(err, errNoSubscriptionEndpoint) {
+			r.Logger.Warn("No healthy RPC endpoint supports subscriptions, polling for logs")
+			sub, err = Poll(ctx, client, query, r.BatchSize, r.PollInterval, logs)
+		} else {
+			restored = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case vLog := <-logs:
+			if vLog.Removed {
+				pos.rewind(vLog)
+			} else {
+				// Only the overlap replayed after a reconnect is deduplicated; live logs
+				// below the cursor are replacements for a reorged block
+				if pos.replaying && pos.seen(vLog) {
+					continue
+				}
+				pos.replaying = false
+				last := vLog
+				pos.last = &last
+				// The block is fetched again on reconnect, as not all of its logs may have arrived
+				pos.resumeFrom = vLog.BlockNumber
+			}
+
+			select {
+			case out <- vLog:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case err := <-sub.Err():
+			if err == nil {
+				err = errSubscriptionClosed
+			}
+			return err
+		case <-restored:
+			return errSubscriptionRestored
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// redial dials the client with exponential backoff, returning nil once cancelled.
+func (r *Reconnector) redial(ctx context.Context) Client {
+	backoff := r.MinBackoff
+	if backoff <= 0 {
+		backoff = DefaultMinBackoff
+	}
+	maxBackoff := r.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+
+	for {
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil
+		}
+
+		client, err := r.Dial(ctx)
+		if err == nil {
+			return client
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		r.Logger.Warnf("Failed to reconnect to the Ethereum client: %v. Retrying in %s", err, backoff)
+	}
+}
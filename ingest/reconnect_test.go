@@ -0,0 +1,212 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconnector(t *testing.T) {
+	chainLogs := []types.Log{testLog(5, 0), testLog(6, 0), testLog(6, 1), testLog(8, 0)}
+
+	// The first connection drops after delivering part of block 6
+	first := &fakeClient{logs: chainLogs[:2], head: 6, subErr: make(chan error, 1)}
+	second := &fakeClient{logs: chainLogs, head: 8}
+
+	var mu sync.Mutex
+	dials := 0
+	dial := func(ctx context.Context) (Client, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		dials++
+		switch dials {
+		case 1:
+			return first, nil
+		case 2:
+			return nil, errors.New("connection refused")
+		default:
+			return second, nil
+		}
+	}
+
+	reconnector := &Reconnector{
+		Dial:       dial,
+		Logger:     logrus.New(),
+		Query:      ethereum.FilterQuery{FromBlock: big.NewInt(5)},
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+	}
+
+	out := make(chan types.Log)
+	sub, err := reconnector.Subscribe(context.Background(), out)
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	logs := receive(t, out, 2)
+	first.subErr <- errors.New("websocket: close 1006")
+
+	logs = append(logs, receive(t, out, 2)...)
+
+	var positions [][2]uint64
+	for _, l := range logs {
+		positions = append(positions, [2]uint64{l.BlockNumber, uint64(l.Index)})
+	}
+	assert.Equal(t, [][2]uint64{{5, 0}, {6, 0}, {6, 1}, {8, 0}}, positions, "Missed logs should be backfilled without duplicates")
+	assert.Equal(t, uint64(1), reconnector.Reconnects())
+
+	second.mu.Lock()
+	defer second.mu.Unlock()
+	assert.Equal(t, uint64(6), second.ranges[0][0], "Backfill should resume from the last forwarded block")
+}
+
+func TestCursorSeen(t *testing.T) {
+	pos := &cursor{}
+	assert.False(t, pos.seen(testLog(1, 0)))
+
+	last := testLog(5, 2)
+	pos.last = &last
+	assert.True(t, pos.seen(testLog(4, 9)))
+	assert.True(t, pos.seen(testLog(5, 2)))
+	assert.False(t, pos.seen(testLog(5, 3)))
+	assert.False(t, pos.seen(testLog(6, 0)))
+
+	replaced := testLog(5, 1)
+	replaced.BlockHash = common.HexToHash("0x01")
+	assert.False(t, pos.seen(replaced), "Logs of a replacement block should not be seen")
+}
+
+func TestReconnector_ReplacedBlock(t *testing.T) {
+	// The last forwarded block is replaced by a shorter one during the outage
+	original := []types.Log{testLog(6, 0), testLog(6, 1), testLog(6, 2)}
+	replacement := testLog(6, 0)
+	replacement.BlockHash = common.HexToHash("0x06")
+	first := &fakeClient{logs: original, head: 6, subErr: make(chan error, 1)}
+	second := &fakeClient{logs: []types.Log{replacement, testLog(7, 0)}, head: 7}
+
+	var mu sync.Mutex
+	dials := 0
+	dial := func(ctx context.Context) (Client, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		dials++
+		if dials == 1 {
+			return first, nil
+		}
+		return second, nil
+	}
+
+	reconnector := &Reconnector{
+		Dial:       dial,
+		Logger:     logrus.New(),
+		Query:      ethereum.FilterQuery{FromBlock: big.NewInt(6)},
+		MinBackoff: time.Millisecond,
+	}
+
+	out := make(chan types.Log)
+	sub, err := reconnector.Subscribe(context.Background(), out)
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	logs := receive(t, out, 3)
+	first.subErr <- errors.New("websocket: close 1006")
+
+	logs = append(logs, receive(t, out, 2)...)
+	assert.Equal(t, append(original, replacement, testLog(7, 0)), logs, "Logs of the replacement block should not be dropped as seen")
+}
+
+func TestReconnector_ReorgReplacement(t *testing.T) {
+	client := &fakeClient{head: 10}
+	reconnector := &Reconnector{
+		Dial:   func(ctx context.Context) (Client, error) { return client, nil },
+		Logger: logrus.New(),
+		Query:  ethereum.FilterQuery{FromBlock: big.NewInt(11)},
+	}
+
+	out := make(chan types.Log)
+	sub, err := reconnector.Subscribe(context.Background(), out)
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	live := waitLive(t, client)
+	orphaned := testLog(11, 3)
+	removed := orphaned
+	removed.Removed = true
+	replacement := testLog(11, 0)
+	live <- orphaned
+	live <- removed
+	live <- replacement
+
+	logs := receive(t, out, 3)
+	assert.Equal(t, []types.Log{orphaned, removed, replacement}, logs, "Replacement logs of a reorged block should be forwarded")
+}
+
+func TestCursorRewind(t *testing.T) {
+	last := testLog(11, 3)
+	pos := &cursor{resumeFrom: 11, last: &last, replaying: true}
+
+	pos.rewind(testLog(10, 1))
+	assert.Nil(t, pos.last)
+	assert.False(t, pos.replaying)
+	assert.Equal(t, uint64(10), pos.resumeFrom)
+}
+
+// waitLive waits for the client's live subscription to be opened
+func waitLive(t *testing.T, client *fakeClient) chan<- types.Log {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		client.mu.Lock()
+		live := client.live
+		client.mu.Unlock()
+		if live != nil {
+			return live
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for the live subscription")
+	return nil
+}
+
+func TestReconnector_PollingFallback(t *testing.T) {
+	primary := &fakeClient{head: 10, err: errors.New("websocket: close 1006")}
+	backup := &fakeClient{logs: []types.Log{testLog(11, 0)}, head: 11}
+	failover := NewFailover([]*Endpoint{
+		{URL: "wss://primary", Priority: 1, Backend: primary},
+		{URL: "https://backup", Priority: 2, Backend: backup},
+	}, logrus.New())
+	assert.NoError(t, failover.HealthCheck(context.Background()))
+
+	reconnector := &Reconnector{
+		Dial:         func(ctx context.Context) (Client, error) { return failover, nil },
+		Logger:       logrus.New(),
+		Query:        ethereum.FilterQuery{FromBlock: big.NewInt(11)},
+		PollInterval: 5 * time.Millisecond,
+		MinBackoff:   time.Millisecond,
+	}
+
+	out := make(chan types.Log)
+	sub, err := reconnector.Subscribe(context.Background(), out)
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	logs := receive(t, out, 1)
+	assert.Equal(t, uint64(11), logs[0].BlockNumber, "Logs should be polled from the HTTP endpoint while no websocket endpoint is healthy")
+
+	// Once the websocket endpoint recovers, the stream subscribes on it again
+	primary.mu.Lock()
+	primary.err = nil
+	primary.head = 11
+	primary.mu.Unlock()
+	assert.NoError(t, failover.HealthCheck(context.Background()))
+	waitLive(t, primary)
+	assert.Equal(t, uint64(1), reconnector.Reconnects())
+}
@@ -0,0 +1,85 @@
+package ingest
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// liveBufferSize is the number of live logs held while a backfill is running.
+const liveBufferSize = 1024
+
+// Subscribe streams the logs matching query into out. When query.FromBlock is set, the
+// logs from that block up to the current head are backfilled first. The live
+// subscription is opened before the head is read, so logs mined during the backfill
+// are buffered and only those above the handover block are forwarded. Removed logs
+// are always forwarded so reorgs of backfilled blocks are still seen.
+func Subscribe(ctx context.Context, client Client, query ethereum.FilterQuery, batchSize uint64, out chan<- types.Log) (ethereum.Subscription, error) {
+	liveQuery := query
+	liveQuery.FromBlock = nil
+	liveQuery.ToBlock = nil
+
+	live := make(chan types.Log, liveBufferSize)
+	liveSub, err := client.SubscribeFilterLogs(ctx, liveQuery, live)
+	if err != nil {
+		return nil, err
+	}
+
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer liveSub.Unsubscribe()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-quit:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		backfilled := query.FromBlock != nil
+		var handover uint64
+		if backfilled {
+			head, err := client.BlockNumber(ctx)
+			if err != nil {
+				return stopped(ctx, err)
+			}
+			handover = head
+
+			if from := query.FromBlock.Uint64(); from <= head {
+				if err := Backfill(ctx, client, query, from, head, batchSize, out); err != nil {
+					return stopped(ctx, err)
+				}
+			}
+		}
+
+		for {
+			select {
+			case vLog := <-live:
+				if backfilled && vLog.BlockNumber <= handover && !vLog.Removed {
+					continue
+				}
+				select {
+				case out <- vLog:
+				case <-ctx.Done():
+					return nil
+				}
+			case err := <-liveSub.Err():
+				return err
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}), nil
+}
+
+// stopped hides errors caused by the subscription being cancelled.
+func stopped(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
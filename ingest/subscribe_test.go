@@ -0,0 +1,66 @@
+package ingest
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// receive reads n logs from the channel, failing the test on timeout
+func receive(t *testing.T, ch <-chan types.Log, n int) []types.Log {
+	t.Helper()
+	var result []types.Log
+	for len(result) < n {
+		select {
+		case l := <-ch:
+			result = append(result, l)
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out after receiving %d of %d logs", len(result), n)
+		}
+	}
+	return result
+}
+
+func TestSubscribe_Handover(t *testing.T) {
+	client := &fakeClient{
+		logs: []types.Log{testLog(5, 0), testLog(8, 0), testLog(10, 0)},
+		head: 10,
+	}
+
+	out := make(chan types.Log)
+	query := ethereum.FilterQuery{FromBlock: big.NewInt(5)}
+	sub, err := Subscribe(context.Background(), client, query, 3, out)
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	// Logs at or below the handover block arrive on the live feed as well
+	client.live <- testLog(10, 0)
+	client.live <- testLog(11, 0)
+
+	logs := receive(t, out, 4)
+	var blocks []uint64
+	for _, l := range logs {
+		blocks = append(blocks, l.BlockNumber)
+	}
+	assert.Equal(t, []uint64{5, 8, 10, 11}, blocks, "Handover block should not be duplicated")
+}
+
+func TestSubscribe_LiveOnly(t *testing.T) {
+	client := &fakeClient{logs: []types.Log{testLog(5, 0)}, head: 10}
+
+	out := make(chan types.Log)
+	sub, err := Subscribe(context.Background(), client, ethereum.FilterQuery{}, 0, out)
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	client.live <- testLog(3, 0)
+
+	logs := receive(t, out, 1)
+	assert.Equal(t, uint64(3), logs[0].BlockNumber)
+	assert.Empty(t, client.ranges, "No backfill should run without a start block")
+}
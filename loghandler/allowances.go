@@ -0,0 +1,58 @@
+package loghandler
+
+import (
+	"context"
+	"fmt"
+	"go-contract-indexer/erc20"
+	"go-contract-indexer/parser"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// TransactionReader fetches the transactions that emitted logs.
+type TransactionReader interface {
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+	TransactionSender(ctx context.Context, tx *types.Transaction, block common.Hash, index uint) (common.Address, error)
+}
+
+// transferFromSpender returns the caller of a direct transferFrom call on the token that
+// emitted a Transfer log, or nil if the transfer was not made that way. Transfers made
+// by other contracts on behalf of the caller cannot be told apart from the input of the
+// transaction and are not attributed to a spender.
+func (h *LogHandler) transferFromSpender(ctx context.Context, e *parser.ERC20Transfer, vLog types.Log) (*common.Address, error) {
+	if e.From == (common.Address{}) {
+		return nil, nil
+	}
+
+	tx, _, err := h.Transactions.TransactionByHash(ctx, vLog.TxHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transaction %s: %v", vLog.TxHash.Hex(), err)
+	}
+	if tx.To() == nil || *tx.To() != vLog.Address {
+		return nil, nil
+	}
+
+	erc20ABI, err := erc20.Erc20MetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	transferFrom := erc20ABI.Methods["transferFrom"]
+	input := tx.Data()
+	if len(input) < 4 || string(input[:4]) != string(transferFrom.ID) {
+		return nil, nil
+	}
+	args, err := transferFrom.Inputs.Unpack(input[4:])
+	if err != nil {
+		return nil, nil
+	}
+	if args[0].(common.Address) != e.From || args[1].(common.Address) != e.To {
+		return nil, nil
+	}
+
+	sender, err := h.Transactions.TransactionSender(ctx, tx, vLog.BlockHash, vLog.TxIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recover the sender of %s: %v", vLog.TxHash.Hex(), err)
+	}
+	return &sender, nil
+}
@@ -0,0 +1,86 @@
+package loghandler
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"go-contract-indexer/db"
+	"go-contract-indexer/erc20"
+	"go-contract-indexer/parser"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTransactions serves transactions sent by a single account
+type fakeTransactions struct {
+	sender common.Address
+	txs    map[common.Hash]*types.Transaction
+}
+
+func (f *fakeTransactions) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	tx, ok := f.txs[hash]
+	if !ok {
+		return nil, false, ethereum.NotFound
+	}
+	return tx, false, nil
+}
+
+func (f *fakeTransactions) TransactionSender(ctx context.Context, tx *types.Transaction, block common.Hash, index uint) (common.Address, error) {
+	return f.sender, nil
+}
+
+func TestHandleLog_TransferFrom(t *testing.T) {
+	parser.Init()
+
+	token := common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	owner := common.HexToAddress("0x1234567890abcdef1234567890abcdef12345678")
+	recipient := common.HexToAddress("0x1234567890abcdef1234567890abcdef12345679")
+	spender := common.HexToAddress("0x1234567890abcdef1234567890abcdef1234567a")
+
+	erc20ABI, err := erc20.Erc20MetaData.GetAbi()
+	assert.NoError(t, err)
+	transferFromInput, err := erc20ABI.Pack("transferFrom", owner, recipient, big.NewInt(5))
+	assert.NoError(t, err)
+	transferInput, err := erc20ABI.Pack("transfer", recipient, big.NewInt(5))
+	assert.NoError(t, err)
+
+	transferFromTx := types.NewTx(&types.LegacyTx{To: &token, Data: transferFromInput})
+	transferTx := types.NewTx(&types.LegacyTx{To: &token, Data: transferInput})
+
+	store := db.NewMemoryDB()
+	logHandler := NewLogHandler(store, logrus.New())
+	logHandler.Transactions = &fakeTransactions{
+		sender: spender,
+		txs:    map[common.Hash]*types.Transaction{transferFromTx.Hash(): transferFromTx, transferTx.Hash(): transferTx},
+	}
+
+	transferLog := func(txHash common.Hash) types.Log {
+		return types.Log{
+			Address: token,
+			Topics: []common.Hash{
+				parser.TransferEventSigHash,
+				common.BytesToHash(owner.Bytes()),
+				common.BytesToHash(recipient.Bytes()),
+			},
+			Data:        common.LeftPadBytes(big.NewInt(5).Bytes(), 32),
+			BlockNumber: 3,
+			TxHash:      txHash,
+		}
+	}
+
+	ctx := context.Background()
+	logHandler.HandleLog(ctx, transferLog(transferFromTx.Hash()))
+	logHandler.HandleLog(ctx, transferLog(transferTx.Hash()))
+
+	transfers := storedTransfers(store)
+	assert.Len(t, transfers, 2)
+	if assert.NotNil(t, transfers[0].Spender, "transferFrom calls should be attributed to their caller") {
+		assert.Equal(t, spender.Hex(), *transfers[0].Spender)
+	}
+	assert.Nil(t, transfers[1].Spender, "Plain transfers should not consume an allowance")
+}
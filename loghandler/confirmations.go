@@ -0,0 +1,154 @@
+package loghandler
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// headPollInterval is how often the chain head is refreshed while logs are buffered.
+var headPollInterval = 12 * time.Second
+
+// Finality tags that can be waited for instead of, or in addition to, a confirmation depth.
+const (
+	FinalitySafe      = "safe"
+	FinalityFinalized = "finalized"
+)
+
+// ParseFinality validates a finality tag, accepting an empty string to disable it.
+func ParseFinality(tag string) (string, error) {
+	switch tag {
+	case "", FinalitySafe, FinalityFinalized:
+		return tag, nil
+	default:
+		return "", fmt.Errorf("unknown finality tag %q, expected %q or %q", tag, FinalitySafe, FinalityFinalized)
+	}
+}
+
+// buffering reports whether logs are held back until their block is confirmed.
+func (h *LogHandler) buffering() bool {
+	return h.Confirmations > 0 || h.Finality != ""
+}
+
+// bufferLog holds a log until its block is confirmed, dropping it again if the log is
+// removed by a reorg before then.
+func (h *LogHandler) bufferLog(ctx context.Context, vLog types.Log) {
+	if h.pending == nil {
+		h.pending = make(map[uint64][]types.Log)
+	}
+
+	if vLog.Removed {
+		if h.dropPending(vLog) {
+			h.Logger.Debugf("Dropped unconfirmed log removed by reorg at block %d", vLog.BlockNumber)
+			return
+		}
+		// The log was already persisted
+		h.handleRemovedLog(vLog)
+		return
+	}
+
+	// Logs from a different version of the same block replace the buffered ones
+	if logs := h.pending[vLog.BlockNumber]; len(logs) > 0 && logs[0].BlockHash != vLog.BlockHash {
+		h.Logger.Warnf("Unconfirmed block %d replaced by %s", vLog.BlockNumber, vLog.BlockHash.Hex())
+		delete(h.pending, vLog.BlockNumber)
+	}
+
+	h.pending[vLog.BlockNumber] = append(h.pending[vLog.BlockNumber], vLog)
+	if vLog.BlockNumber > h.head {
+		h.head = vLog.BlockNumber
+	}
+	if !h.headFetched && h.Chain != nil {
+		h.refreshHead(ctx)
+	}
+
+	h.flushConfirmed(ctx)
+}
+
+// dropPending removes a buffered log, returning false if it was not buffered.
+func (h *LogHandler) dropPending(vLog types.Log) bool {
+	logs := h.pending[vLog.BlockNumber]
+	for i, pending := range logs {
+		if pending.BlockHash == vLog.BlockHash && pending.TxHash == vLog.TxHash && pending.Index == vLog.Index {
+			h.pending[vLog.BlockNumber] = append(logs[:i], logs[i+1:]...)
+			if len(h.pending[vLog.BlockNumber]) == 0 {
+				delete(h.pending, vLog.BlockNumber)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// refreshHead updates the chain head and the finalized or safe block from the node.
+func (h *LogHandler) refreshHead(ctx context.Context) {
+	header, err := h.Chain.HeaderByNumber(ctx, nil)
+	if err != nil {
+		h.Logger.Errorf("Failed to fetch chain head: %v", err)
+		return
+	}
+	if number := header.Number.Uint64(); number > h.head {
+		h.head = number
+	}
+	h.headFetched = true
+
+	if h.Finality == "" {
+		return
+	}
+
+	tag := rpc.FinalizedBlockNumber
+	if h.Finality == FinalitySafe {
+		tag = rpc.SafeBlockNumber
+	}
+	header, err = h.Chain.HeaderByNumber(ctx, big.NewInt(int64(tag)))
+	if err != nil {
+		h.Logger.Errorf("Failed to fetch %s block: %v", h.Finality, err)
+		return
+	}
+	h.finalized = header.Number.Uint64()
+}
+
+// confirmedBlock returns the highest block whose logs can be persisted, or false if there is none yet.
+func (h *LogHandler) confirmedBlock() (uint64, bool) {
+	if h.head < h.Confirmations {
+		return 0, false
+	}
+	confirmed := h.head - h.Confirmations
+
+	if h.Finality != "" {
+		if h.Chain == nil || h.finalized == 0 {
+			return 0, false
+		}
+		if h.finalized < confirmed {
+			confirmed = h.finalized
+		}
+	}
+	return confirmed, true
+}
+
+// flushConfirmed persists the buffered logs of every confirmed block in block order.
+func (h *LogHandler) flushConfirmed(ctx context.Context) {
+	confirmed, ok := h.confirmedBlock()
+	if !ok {
+		return
+	}
+
+	var blocks []uint64
+	for number := range h.pending {
+		if number <= confirmed {
+			blocks = append(blocks, number)
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+
+	for _, number := range blocks {
+		for _, vLog := range h.pending[number] {
+			h.persistLog(ctx, vLog)
+		}
+		delete(h.pending, number)
+	}
+}
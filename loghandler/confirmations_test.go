@@ -0,0 +1,109 @@
+package loghandler
+
+import (
+	"context"
+	"testing"
+
+	"go-contract-indexer/db"
+	"go-contract-indexer/parser"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfirmations(t *testing.T) {
+	parser.Init()
+
+	chain := newFakeChain()
+	chain.extend(0, 10, "a")
+	store := db.NewMemoryDB()
+	logHandler := NewLogHandler(store, logrus.New())
+	logHandler.Confirmations = 2
+
+	ctx := context.Background()
+	logHandler.HandleLog(ctx, chain.transfer(1))
+	logHandler.HandleLog(ctx, chain.transfer(2))
+	assert.Empty(t, eventBlocks(store), "Logs should be held until confirmed")
+
+	logHandler.HandleLog(ctx, chain.transfer(3))
+	assert.Equal(t, []uint64{1}, eventBlocks(store))
+
+	logHandler.HandleLog(ctx, chain.transfer(6))
+	assert.Equal(t, []uint64{1, 2, 3}, eventBlocks(store), "Confirmed blocks should be stored in order")
+}
+
+func TestConfirmations_RemovedBeforeConfirmed(t *testing.T) {
+	parser.Init()
+
+	chain := newFakeChain()
+	chain.extend(0, 10, "a")
+	store := db.NewMemoryDB()
+	logHandler := NewLogHandler(store, logrus.New())
+	logHandler.Confirmations = 3
+
+	ctx := context.Background()
+	orphaned := chain.transfer(4)
+	logHandler.HandleLog(ctx, orphaned)
+
+	orphaned.Removed = true
+	logHandler.HandleLog(ctx, orphaned)
+
+	chain.extend(4, 10, "b")
+	logHandler.HandleLog(ctx, chain.transfer(10))
+	assert.Empty(t, eventBlocks(store), "Removed logs should never be stored")
+	_, stored, _ := store.GetBlockHash(4)
+	assert.False(t, stored, "No block should be rolled back or stored")
+}
+
+func TestConfirmations_ReplacedBlock(t *testing.T) {
+	parser.Init()
+
+	chain := newFakeChain()
+	chain.extend(0, 10, "a")
+	store := db.NewMemoryDB()
+	logHandler := NewLogHandler(store, logrus.New())
+	logHandler.Confirmations = 1
+
+	ctx := context.Background()
+	logHandler.HandleLog(ctx, chain.transfer(5))
+
+	chain.extend(5, 10, "b")
+	replacement := chain.transfer(5)
+	logHandler.HandleLog(ctx, replacement)
+	logHandler.HandleLog(ctx, chain.transfer(6))
+
+	assert.Equal(t, []uint64{5}, eventBlocks(store), "Only the replacement block should be stored")
+	assert.Equal(t, replacement.BlockHash.Hex(), blockHash(store, 5))
+}
+
+func TestFinality(t *testing.T) {
+	parser.Init()
+
+	chain := newFakeChain()
+	chain.extend(0, 10, "a")
+	chain.finalized = 4
+	store := db.NewMemoryDB()
+	logHandler := NewLogHandler(store, logrus.New())
+	logHandler.Chain = chain
+	logHandler.Finality = FinalityFinalized
+
+	ctx := context.Background()
+	logHandler.HandleLog(ctx, chain.transfer(3))
+	logHandler.HandleLog(ctx, chain.transfer(5))
+	assert.Equal(t, []uint64{3}, eventBlocks(store), "Only finalized blocks should be stored")
+
+	chain.finalized = 8
+	logHandler.refreshHead(ctx)
+	logHandler.flushConfirmed(ctx)
+	assert.Equal(t, []uint64{3, 5}, eventBlocks(store))
+}
+
+func TestParseFinality(t *testing.T) {
+	for _, tag := range []string{"", FinalitySafe, FinalityFinalized} {
+		_, err := ParseFinality(tag)
+		assert.NoError(t, err)
+	}
+
+	_, err := ParseFinality("latest")
+	assert.Error(t, err)
+}
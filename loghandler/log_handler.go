@@ -2,10 +2,17 @@ package loghandler
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"go-contract-indexer/db"
 	"go-contract-indexer/parser"
+	"math/big"
+	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/sirupsen/logrus"
 )
@@ -14,6 +21,28 @@ import (
 type LogHandler struct {
 	DB     db.Interface
 	Logger logrus.FieldLogger
+	// Chain is used to verify block ancestry and re-fetch logs after a reorg. Reorgs are
+	// only detected through removed logs and replaced block hashes when it is nil.
+	Chain ChainReader
+	// Addresses are the contracts whose logs are re-fetched after a reorg.
+	Addresses []common.Address
+	// StartBlocks optionally sets the first block indexed for each contract. Logs of a
+	// contract below its start block are ignored, as they were already indexed or
+	// precede the configured start.
+	StartBlocks map[common.Address]uint64
+	// Confirmations is the number of blocks a log must be buried under before it is stored.
+	Confirmations uint64
+	// Finality optionally holds logs back until the node's "safe" or "finalized" block
+	// has reached them. It requires Chain to be set.
+	Finality string
+	// Transactions optionally decodes the transactions of Transfer logs, so allowances
+	// consumed by transferFrom calls are tracked. It costs one request per Transfer.
+	Transactions TransactionReader
+
+	pending     map[uint64][]types.Log
+	head        uint64
+	headFetched bool
+	finalized   uint64
 }
 
 // NewLogHandler creates a new instance of LogHandler.
@@ -24,54 +53,330 @@ func NewLogHandler(db db.Interface, logger logrus.FieldLogger) *LogHandler {
 	}
 }
 
-// HandleLogs processes the logs received from the Ethereum client.
-func (h *LogHandler) HandleLogs(ctx context.Context, logs chan types.Log, sub ethereum.Subscription) {
+// HandleLogs processes the logs received from the Ethereum client until the context is
+// cancelled or the subscription fails, in which case the subscription error is returned.
+func (h *LogHandler) HandleLogs(ctx context.Context, logs chan types.Log, sub ethereum.Subscription) error {
+	// Buffered logs are flushed as the head advances, even when no new logs arrive
+	var headTicks <-chan time.Time
+	if h.buffering() && h.Chain != nil {
+		ticker := time.NewTicker(headPollInterval)
+		defer ticker.Stop()
+		headTicks = ticker.C
+	}
+
 	for {
 		select {
 		case err := <-sub.Err():
-			h.Logger.Fatalf("Subscription error: %v", err)
-		case vLog := <-logs:
-			h.Logger.Debugf("Received log: %v", vLog)
-			event, err := parser.UnpackLog(vLog)
-			if err != nil {
-				h.Logger.Printf("Failed to unpack log: %v", err)
-				continue
-			}
-
-			switch e := event.(type) {
-			case *parser.ERC20Transfer:
-				h.handleTransferEvent(e, vLog)
-			case *parser.ERC20Approval:
-				h.handleApprovalEvent(e, vLog)
-			default:
-				h.Logger.Printf("Unknown event type")
+			if err == nil {
+				h.Logger.Info("Subscription closed")
+				return nil
 			}
+			return fmt.Errorf("subscription error: %v", err)
+		case vLog := <-logs:
+			h.HandleLog(ctx, vLog)
+		case <-headTicks:
+			h.refreshHead(ctx)
+			h.flushConfirmed(ctx)
 		case <-ctx.Done():
 			h.Logger.Info("Shutting down log handling")
 			sub.Unsubscribe()
-			return
+			return nil
 		}
 	}
 }
 
+// HandleLog processes a single log. When a confirmation depth or finality tag is set,
+// the log is buffered until its block is confirmed.
+func (h *LogHandler) HandleLog(ctx context.Context, vLog types.Log) {
+	h.Logger.Debugf("Received log: %v", vLog)
+	if start, ok := h.StartBlocks[vLog.Address]; ok && vLog.BlockNumber < start && !vLog.Removed {
+		return
+	}
+	if h.buffering() {
+		h.bufferLog(ctx, vLog)
+		return
+	}
+	h.persistLog(ctx, vLog)
+}
+
+// persistLog rolls back reorged blocks if needed and stores the event of a log.
+func (h *LogHandler) persistLog(ctx context.Context, vLog types.Log) {
+	if vLog.Removed {
+		h.handleRemovedLog(vLog)
+		return
+	}
+
+	if err := h.trackBlock(ctx, vLog); err != nil {
+		h.Logger.Errorf("Failed to check block %d for reorgs: %v", vLog.BlockNumber, err)
+	}
+
+	h.processLog(ctx, vLog)
+}
+
+// processLog unpacks a log and stores its event.
+func (h *LogHandler) processLog(ctx context.Context, vLog types.Log) {
+	event, err := parser.UnpackLog(vLog)
+	if err != nil {
+		h.quarantineLog(vLog, err)
+		return
+	}
+
+	switch e := event.(type) {
+	case *parser.ERC20Transfer:
+		h.handleTransferEvent(ctx, e, vLog)
+	case *parser.ERC20Approval:
+		h.handleApprovalEvent(e, vLog)
+	case *parser.ERC721Transfer:
+		h.handleNFTTransferEvent(e, vLog)
+	case *parser.ERC721Approval:
+		h.handleNFTApprovalEvent(e, vLog)
+	case *parser.ApprovalForAll:
+		h.handleApprovalForAllEvent(e, vLog)
+	case *parser.ERC1155TransferSingle:
+		h.handleTransferSingleEvent(e, vLog)
+	case *parser.ERC1155TransferBatch:
+		h.handleTransferBatchEvent(e, vLog)
+	case *parser.ERC1155URI:
+		h.handleURIEvent(e, vLog)
+	case *parser.DecodedEvent:
+		h.handleDecodedEvent(e, vLog)
+	default:
+		h.Logger.Printf("Unknown event type")
+	}
+}
+
+// quarantineLog stores the raw topics and data of a log that could not be decoded.
+func (h *LogHandler) quarantineLog(vLog types.Log, reason error) {
+	h.Logger.Warnf("Quarantining log %d of tx %s: %v", vLog.Index, vLog.TxHash.Hex(), reason)
+
+	topics := make([]string, len(vLog.Topics))
+	for i, topic := range vLog.Topics {
+		topics[i] = topic.Hex()
+	}
+	err := h.DB.QuarantineLog(db.QuarantinedLog{
+		ContractAddress: vLog.Address.Hex(),
+		BlockNumber:     vLog.BlockNumber,
+		BlockHash:       vLog.BlockHash.Hex(),
+		TxHash:          vLog.TxHash.Hex(),
+		LogIndex:        vLog.Index,
+		Topics:          strings.Join(topics, ","),
+		Data:            hexutil.Encode(vLog.Data),
+		Reason:          reason.Error(),
+	})
+	if err != nil {
+		h.Logger.Errorf("Failed to quarantine log: %v", err)
+	}
+}
+
 // handleTransferEvent handles the Transfer event logs.
-func (h *LogHandler) handleTransferEvent(e *parser.ERC20Transfer, vLog types.Log) {
+func (h *LogHandler) handleTransferEvent(ctx context.Context, e *parser.ERC20Transfer, vLog types.Log) {
 	from := e.From.Hex()
 	to := e.To.Hex()
-	h.Logger.Infof("Handling Transfer Event: From %s To %s Value %s", from, to, e.Value.String())
-	err := h.DB.SaveEvent(vLog.BlockNumber, vLog.TxHash.Hex(), "Transfer", &from, &to, nil, nil, e.Value)
+	eventType := transferType(e)
+	h.Logger.Infof("Handling %s Event: Contract %s From %s To %s Value %s", eventType, vLog.Address.Hex(), from, to, e.Value.String())
+
+	var spender *string
+	if h.Transactions != nil {
+		caller, err := h.transferFromSpender(ctx, e, vLog)
+		if err != nil {
+			h.Logger.Warnf("Failed to check transfer for allowance use: %v", err)
+		} else if caller != nil {
+			hex := caller.Hex()
+			spender = &hex
+		}
+	}
+
+	err := h.DB.SaveEvent(db.Event{
+		ContractAddress: vLog.Address.Hex(),
+		BlockNumber:     vLog.BlockNumber,
+		BlockHash:       vLog.BlockHash.Hex(),
+		TxHash:          vLog.TxHash.Hex(),
+		LogIndex:        vLog.Index,
+		EventType:       eventType,
+		From:            &from,
+		To:              &to,
+		Spender:         spender,
+		Value:           e.Value,
+	})
 	if err != nil {
 		h.Logger.Errorf("Failed to save transfer event: %v", err)
 	}
 }
 
+// transferType classifies transfers from the zero address as mints and transfers to it as burns.
+func transferType(e *parser.ERC20Transfer) string {
+	switch {
+	case e.From == (common.Address{}):
+		return "Mint"
+	case e.To == (common.Address{}):
+		return "Burn"
+	default:
+		return "Transfer"
+	}
+}
+
 // handleApprovalEvent handles the Approval event logs.
 func (h *LogHandler) handleApprovalEvent(e *parser.ERC20Approval, vLog types.Log) {
 	owner := e.Owner.Hex()
 	spender := e.Spender.Hex()
-	h.Logger.Infof("Handling Approval Event: Owner %s Spender %s Value %s", owner, spender, e.Value.String())
-	err := h.DB.SaveEvent(vLog.BlockNumber, vLog.TxHash.Hex(), "Approval", nil, nil, &owner, &spender, e.Value)
+	h.Logger.Infof("Handling Approval Event: Contract %s Owner %s Spender %s Value %s", vLog.Address.Hex(), owner, spender, e.Value.String())
+	err := h.DB.SaveEvent(db.Event{
+		ContractAddress: vLog.Address.Hex(),
+		BlockNumber:     vLog.BlockNumber,
+		BlockHash:       vLog.BlockHash.Hex(),
+		TxHash:          vLog.TxHash.Hex(),
+		LogIndex:        vLog.Index,
+		EventType:       "Approval",
+		Owner:           &owner,
+		Spender:         &spender,
+		Value:           e.Value,
+	})
 	if err != nil {
 		h.Logger.Errorf("Failed to save approval event: %v", err)
 	}
 }
+
+// handleNFTTransferEvent handles the Transfer event logs of non-fungible tokens.
+func (h *LogHandler) handleNFTTransferEvent(e *parser.ERC721Transfer, vLog types.Log) {
+	from := e.From.Hex()
+	to := e.To.Hex()
+	h.Logger.Infof("Handling NFT Transfer Event: Contract %s From %s To %s TokenID %s", vLog.Address.Hex(), from, to, e.TokenID.String())
+	err := h.DB.SaveNFTEvents([]db.NFTEvent{{
+		ContractAddress: vLog.Address.Hex(),
+		BlockNumber:     vLog.BlockNumber,
+		BlockHash:       vLog.BlockHash.Hex(),
+		TxHash:          vLog.TxHash.Hex(),
+		LogIndex:        vLog.Index,
+		EventType:       "Transfer",
+		From:            &from,
+		To:              &to,
+		TokenID:         e.TokenID,
+	}})
+	if err != nil {
+		h.Logger.Errorf("Failed to save NFT transfer event: %v", err)
+	}
+}
+
+// handleNFTApprovalEvent handles the Approval event logs of non-fungible tokens.
+func (h *LogHandler) handleNFTApprovalEvent(e *parser.ERC721Approval, vLog types.Log) {
+	owner := e.Owner.Hex()
+	approved := e.Approved.Hex()
+	h.Logger.Infof("Handling NFT Approval Event: Contract %s Owner %s Approved %s TokenID %s", vLog.Address.Hex(), owner, approved, e.TokenID.String())
+	err := h.DB.SaveNFTEvents([]db.NFTEvent{{
+		ContractAddress: vLog.Address.Hex(),
+		BlockNumber:     vLog.BlockNumber,
+		BlockHash:       vLog.BlockHash.Hex(),
+		TxHash:          vLog.TxHash.Hex(),
+		LogIndex:        vLog.Index,
+		EventType:       "Approval",
+		Owner:           &owner,
+		Approved:        &approved,
+		TokenID:         e.TokenID,
+	}})
+	if err != nil {
+		h.Logger.Errorf("Failed to save NFT approval event: %v", err)
+	}
+}
+
+// handleApprovalForAllEvent handles the ApprovalForAll event logs.
+func (h *LogHandler) handleApprovalForAllEvent(e *parser.ApprovalForAll, vLog types.Log) {
+	owner := e.Owner.Hex()
+	operator := e.Operator.Hex()
+	h.Logger.Infof("Handling ApprovalForAll Event: Contract %s Owner %s Operator %s Approved %t", vLog.Address.Hex(), owner, operator, e.Approved)
+	err := h.DB.SaveNFTEvents([]db.NFTEvent{{
+		ContractAddress: vLog.Address.Hex(),
+		BlockNumber:     vLog.BlockNumber,
+		BlockHash:       vLog.BlockHash.Hex(),
+		TxHash:          vLog.TxHash.Hex(),
+		LogIndex:        vLog.Index,
+		EventType:       "ApprovalForAll",
+		Owner:           &owner,
+		Operator:        &operator,
+		ApprovedForAll:  &e.Approved,
+	}})
+	if err != nil {
+		h.Logger.Errorf("Failed to save ApprovalForAll event: %v", err)
+	}
+}
+
+// handleTransferSingleEvent handles the TransferSingle event logs of multi-tokens.
+func (h *LogHandler) handleTransferSingleEvent(e *parser.ERC1155TransferSingle, vLog types.Log) {
+	h.Logger.Infof("Handling TransferSingle Event: Contract %s From %s To %s ID %s Value %s", vLog.Address.Hex(), e.From.Hex(), e.To.Hex(), e.ID.String(), e.Value.String())
+	err := h.DB.SaveNFTEvents([]db.NFTEvent{multiTokenTransfer("TransferSingle", e.Operator, e.From, e.To, e.ID, e.Value, vLog)})
+	if err != nil {
+		h.Logger.Errorf("Failed to save TransferSingle event: %v", err)
+	}
+}
+
+// handleTransferBatchEvent handles the TransferBatch event logs of multi-tokens, storing
+// one event per transferred ID.
+func (h *LogHandler) handleTransferBatchEvent(e *parser.ERC1155TransferBatch, vLog types.Log) {
+	h.Logger.Infof("Handling TransferBatch Event: Contract %s From %s To %s IDs %v Values %v", vLog.Address.Hex(), e.From.Hex(), e.To.Hex(), e.IDs, e.Values)
+	events := make([]db.NFTEvent, len(e.IDs))
+	for i := range e.IDs {
+		events[i] = multiTokenTransfer("TransferBatch", e.Operator, e.From, e.To, e.IDs[i], e.Values[i], vLog)
+	}
+	if err := h.DB.SaveNFTEvents(events); err != nil {
+		h.Logger.Errorf("Failed to save TransferBatch event: %v", err)
+	}
+}
+
+// multiTokenTransfer creates the stored event of a transfer of a single multi-token ID.
+func multiTokenTransfer(eventType string, operator, from, to common.Address, id, value *big.Int, vLog types.Log) db.NFTEvent {
+	operatorHex, fromHex, toHex := operator.Hex(), from.Hex(), to.Hex()
+	return db.NFTEvent{
+		ContractAddress: vLog.Address.Hex(),
+		BlockNumber:     vLog.BlockNumber,
+		BlockHash:       vLog.BlockHash.Hex(),
+		TxHash:          vLog.TxHash.Hex(),
+		LogIndex:        vLog.Index,
+		EventType:       eventType,
+		From:            &fromHex,
+		To:              &toHex,
+		Operator:        &operatorHex,
+		TokenID:         id,
+		Value:           value,
+	}
+}
+
+// handleURIEvent handles the URI event logs of multi-tokens.
+func (h *LogHandler) handleURIEvent(e *parser.ERC1155URI, vLog types.Log) {
+	h.Logger.Infof("Handling URI Event: Contract %s ID %s URI %s", vLog.Address.Hex(), e.ID.String(), e.Value)
+	err := h.DB.SaveNFTEvents([]db.NFTEvent{{
+		ContractAddress: vLog.Address.Hex(),
+		BlockNumber:     vLog.BlockNumber,
+		BlockHash:       vLog.BlockHash.Hex(),
+		TxHash:          vLog.TxHash.Hex(),
+		LogIndex:        vLog.Index,
+		EventType:       "URI",
+		TokenID:         e.ID,
+		URI:             &e.Value,
+	}})
+	if err != nil {
+		h.Logger.Errorf("Failed to save URI event: %v", err)
+	}
+}
+
+// handleDecodedEvent handles logs of any other event in the ABI.
+func (h *LogHandler) handleDecodedEvent(e *parser.DecodedEvent, vLog types.Log) {
+	args, err := json.Marshal(e.Args)
+	if err != nil {
+		h.Logger.Errorf("Failed to encode %s event arguments: %v", e.Name, err)
+		return
+	}
+
+	h.Logger.Infof("Handling %s Event: Contract %s Args %s", e.Name, vLog.Address.Hex(), args)
+	err = h.DB.SaveContractEvent(db.ContractEvent{
+		ContractAddress: vLog.Address.Hex(),
+		BlockNumber:     vLog.BlockNumber,
+		BlockHash:       vLog.BlockHash.Hex(),
+		TxHash:          vLog.TxHash.Hex(),
+		LogIndex:        vLog.Index,
+		EventName:       e.Name,
+		EventSignature:  e.Signature,
+		Args:            string(args),
+	})
+	if err != nil {
+		h.Logger.Errorf("Failed to save %s event: %v", e.Name, err)
+	}
+}
@@ -2,16 +2,20 @@ package loghandler
 
 import (
 	"context"
+	"errors"
 	"math/big"
 	"os"
 	"sync"
 	"testing"
 	"time"
 
+	"go-contract-indexer/db"
 	"go-contract-indexer/parser"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -36,21 +40,6 @@ func (m *MockSubscription) Err() <-chan error {
 	return args.Get(0).(<-chan error)
 }
 
-// MockDB is a mock of the db package's SaveEvent function
-type MockDB struct {
-	mock.Mock
-}
-
-func (m *MockDB) SaveEvent(blockNumber uint64, txHash, eventType string, from, to, owner, spender *string, value *big.Int) error {
-	args := m.Called(blockNumber, txHash, eventType, from, to, owner, spender, value)
-	return args.Error(0)
-}
-
-func (m *MockDB) Close() error {
-	args := m.Called()
-	return args.Error(0)
-}
-
 func TestHandleTransferLogs(t *testing.T) {
 	// Initialize the ABI
 	parser.Init()
@@ -76,20 +65,19 @@ func TestHandleTransferLogs(t *testing.T) {
 		Address: common.HexToAddress("0x1234567890abcdef1234567890abcdef12345678"),
 		Topics:  []common.Hash{parser.TransferEventSigHash, common.HexToHash("0x1234567890abcdef1234567890abcdef12345678"), common.HexToHash("0x1234567890abcdef1234567890abcdef12345679")},
 		Data:    valueBytes,
+		Index:   5,
 	}
 	logs <- log
 
-	// Create a mock DB
-	mockDB := new(MockDB)
-	mockDB.On("SaveEvent", mock.AnythingOfType("uint64"), mock.AnythingOfType("string"), "Transfer", mock.AnythingOfType("*string"), mock.AnythingOfType("*string"), (*string)(nil), (*string)(nil), mock.AnythingOfType("*big.Int")).Return(nil)
-	mockDB.On("Close").Return(nil).Once() // Ensure it is expected once
+	// Create an in-memory DB
+	store := db.NewMemoryDB()
 
 	// Create a context and a cancel function to simulate graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
 
 	// Create LogHandler instance
-	logHandler := NewLogHandler(mockDB, mockLogger)
+	logHandler := NewLogHandler(store, mockLogger)
 
 	// Start handling logs
 	wg.Add(1)
@@ -113,10 +101,15 @@ func TestHandleTransferLogs(t *testing.T) {
 	// Ensure that the subscription was unsubscribed
 	mockSub.AssertCalled(t, "Unsubscribe")
 
-	// Close the mock database connection
-	err := mockDB.Close()
-	assert.NoError(t, err, "Expected no error while closing the database connection")
-	mockDB.AssertExpectations(t)
+	// Ensure that the event was stored
+	events := store.Events()
+	if assert.Len(t, events, 1) {
+		e := events[0]
+		assert.Equal(t, "Transfer", e.EventType)
+		assert.Equal(t, log.Address.Hex(), e.ContractAddress)
+		assert.Equal(t, uint(5), e.LogIndex)
+		assert.True(t, e.From != nil && e.To != nil && e.Owner == nil && e.Spender == nil && e.Value != nil)
+	}
 }
 
 func TestHandleApprovalLogs(t *testing.T) {
@@ -147,17 +140,15 @@ func TestHandleApprovalLogs(t *testing.T) {
 	}
 	logs <- log
 
-	// Create a mock DB
-	mockDB := new(MockDB)
-	mockDB.On("SaveEvent", mock.AnythingOfType("uint64"), mock.AnythingOfType("string"), "Approval", (*string)(nil), (*string)(nil), mock.AnythingOfType("*string"), mock.AnythingOfType("*string"), mock.AnythingOfType("*big.Int")).Return(nil)
-	mockDB.On("Close").Return(nil).Once() // Ensure it is expected once
+	// Create an in-memory DB
+	store := db.NewMemoryDB()
 
 	// Create a context and a cancel function to simulate graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
 
 	// Create LogHandler instance
-	logHandler := NewLogHandler(mockDB, mockLogger)
+	logHandler := NewLogHandler(store, mockLogger)
 
 	// Start handling logs
 	wg.Add(1)
@@ -183,8 +174,214 @@ func TestHandleApprovalLogs(t *testing.T) {
 	// Ensure that the subscription was unsubscribed
 	mockSub.AssertCalled(t, "Unsubscribe")
 
-	// Close the mock database connection
-	err := mockDB.Close()
-	assert.NoError(t, err, "Expected no error while closing the database connection")
-	mockDB.AssertExpectations(t)
+	// Ensure that the event was stored
+	events := store.Events()
+	if assert.Len(t, events, 1) {
+		e := events[0]
+		assert.Equal(t, "Approval", e.EventType)
+		assert.Equal(t, log.Address.Hex(), e.ContractAddress)
+		assert.True(t, e.From == nil && e.To == nil && e.Owner != nil && e.Spender != nil && e.Value != nil)
+	}
+}
+
+func TestHandleLogs_SubscriptionError(t *testing.T) {
+	// Create mock subscription with a failing error channel
+	mockSub := new(MockSubscription)
+	errChan := make(chan error, 1)
+	errChan <- errors.New("websocket: close 1006")
+	mockSub.On("Err").Return((<-chan error)(errChan))
+
+	logHandler := NewLogHandler(db.NewMemoryDB(), logrus.New())
+
+	// The error should be returned instead of terminating the process
+	err := logHandler.HandleLogs(context.Background(), make(chan types.Log), mockSub)
+	assert.EqualError(t, err, "subscription error: websocket: close 1006")
+}
+
+func TestHandleLog_StartBlocks(t *testing.T) {
+	parser.Init()
+
+	chain := newFakeChain()
+	chain.extend(0, 10, "a")
+	store := db.NewMemoryDB()
+	logHandler := NewLogHandler(store, logrus.New())
+
+	early := chain.transfer(4)
+	logHandler.StartBlocks = map[common.Address]uint64{early.Address: 5}
+
+	ctx := context.Background()
+	logHandler.HandleLog(ctx, early)
+	logHandler.HandleLog(ctx, chain.transfer(5))
+	assert.Equal(t, []uint64{5}, eventBlocks(store), "Logs below the contract's start block should be ignored")
+}
+
+func TestHandleLog_DecodedEvent(t *testing.T) {
+	erc20ABI, err := os.ReadFile("../erc20/erc20.abi")
+	assert.NoError(t, err)
+	parser.SetABI(`[{"anonymous":false,"inputs":[{"indexed":false,"name":"account","type":"address"}],"name":"Paused","type":"event"}]`)
+	defer parser.SetABI(string(erc20ABI))
+
+	store := db.NewMemoryDB()
+	logHandler := NewLogHandler(store, logrus.New())
+
+	log := types.Log{
+		Address:     common.HexToAddress("0x1234567890abcdef1234567890abcdef12345678"),
+		Topics:      []common.Hash{crypto.Keccak256Hash([]byte("Paused(address)"))},
+		Data:        common.LeftPadBytes(common.HexToAddress("0x01").Bytes(), 32),
+		BlockNumber: 7,
+		Index:       3,
+	}
+	logHandler.HandleLog(context.Background(), log)
+
+	contractEvents := store.ContractEvents()
+	assert.Len(t, contractEvents, 1, "Events other than Transfer and Approval should be stored generically")
+	event := contractEvents[0]
+	assert.Equal(t, "Paused", event.EventName)
+	assert.Equal(t, "Paused(address)", event.EventSignature)
+	assert.Equal(t, uint(3), event.LogIndex)
+	assert.JSONEq(t, `[{"name":"account","type":"address","indexed":false,"value":"0x0000000000000000000000000000000000000001"}]`, event.Args)
+}
+
+func TestHandleLog_Quarantine(t *testing.T) {
+	parser.Init()
+
+	store := db.NewMemoryDB()
+	logHandler := NewLogHandler(store, logrus.New())
+
+	log := types.Log{
+		Address:     common.HexToAddress("0x1234567890abcdef1234567890abcdef12345678"),
+		Topics:      []common.Hash{parser.TransferEventSigHash},
+		Data:        []byte{0x01, 0x02},
+		BlockNumber: 9,
+		Index:       1,
+	}
+	logHandler.HandleLog(context.Background(), log)
+
+	assert.Empty(t, eventBlocks(store), "Malformed logs should not be stored as events")
+	quarantined := store.QuarantinedLogs()
+	assert.Len(t, quarantined, 1, "Malformed logs should be quarantined")
+	assert.Equal(t, parser.TransferEventSigHash.Hex(), quarantined[0].Topics)
+	assert.Equal(t, "0x0102", quarantined[0].Data)
+	assert.Contains(t, quarantined[0].Reason, "expected 3 topics")
+}
+
+func TestHandleLog_NFTTransfer(t *testing.T) {
+	parser.Init()
+
+	store := db.NewMemoryDB()
+	logHandler := NewLogHandler(store, logrus.New())
+
+	contract := common.HexToAddress("0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D")
+	owner := common.HexToAddress("0x1234567890abcdef1234567890abcdef12345678")
+	log := types.Log{
+		Address: contract,
+		Topics: []common.Hash{
+			parser.TransferEventSigHash,
+			common.Hash{},
+			common.BytesToHash(owner.Bytes()),
+			common.BigToHash(big.NewInt(42)),
+		},
+		BlockNumber: 5,
+		Index:       2,
+	}
+	logHandler.HandleLog(context.Background(), log)
+
+	nftEvents := store.NFTEvents()
+	assert.Len(t, nftEvents, 1, "ERC-721 Transfers should be stored as NFT events")
+	assert.Equal(t, "Transfer", nftEvents[0].EventType)
+	assert.Equal(t, big.NewInt(42), nftEvents[0].TokenID)
+
+	tokenOwner, ok, err := store.GetTokenOwner(contract.Hex(), big.NewInt(42))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, owner.Hex(), tokenOwner)
+}
+
+func TestHandleLog_TransferBatch(t *testing.T) {
+	parser.Init()
+
+	store := db.NewMemoryDB()
+	logHandler := NewLogHandler(store, logrus.New())
+
+	contract := common.HexToAddress("0x76BE3b62873462d2142405439777e971754E8E77")
+	holder := common.HexToAddress("0x1234567890abcdef1234567890abcdef12345678")
+	uint256Array, _ := abi.NewType("uint256[]", "", nil)
+	data, err := abi.Arguments{{Type: uint256Array}, {Type: uint256Array}}.Pack(
+		[]*big.Int{big.NewInt(1), big.NewInt(2)}, []*big.Int{big.NewInt(10), big.NewInt(20)})
+	assert.NoError(t, err)
+
+	logHandler.HandleLog(context.Background(), types.Log{
+		Address: contract,
+		Topics: []common.Hash{
+			parser.TransferBatchEventSigHash,
+			common.BytesToHash(holder.Bytes()),
+			common.Hash{},
+			common.BytesToHash(holder.Bytes()),
+		},
+		Data:        data,
+		BlockNumber: 6,
+	})
+
+	assert.Len(t, store.NFTEvents(), 2, "A batch should be stored as one event per id")
+	balance, err := store.GetNFTBalance(contract.Hex(), big.NewInt(2), holder.Hex())
+	assert.NoError(t, err)
+	assert.Equal(t, "20", balance.String())
+}
+
+func TestHandleLog_MintAndBurn(t *testing.T) {
+	parser.Init()
+
+	store := db.NewMemoryDB()
+	logHandler := NewLogHandler(store, logrus.New())
+
+	holder := common.BytesToHash(common.HexToAddress("0x1234567890abcdef1234567890abcdef12345678").Bytes())
+	transfer := func(from, to common.Hash, index uint) types.Log {
+		return types.Log{
+			Address:     common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"),
+			Topics:      []common.Hash{parser.TransferEventSigHash, from, to},
+			Data:        common.LeftPadBytes(big.NewInt(10).Bytes(), 32),
+			BlockNumber: 8,
+			Index:       index,
+		}
+	}
+
+	ctx := context.Background()
+	logHandler.HandleLog(ctx, transfer(common.Hash{}, holder, 0))
+	logHandler.HandleLog(ctx, transfer(holder, common.Hash{}, 1))
+
+	transfers := storedTransfers(store)
+	if assert.Len(t, transfers, 2) {
+		assert.Equal(t, "Mint", transfers[0].EventType, "Transfers from the zero address should be mints")
+		assert.Equal(t, "Burn", transfers[1].EventType, "Transfers to the zero address should be burns")
+	}
+}
+
+func TestHandleLog_SQLite(t *testing.T) {
+	parser.Init()
+
+	database := db.InitDB("sqlite://:memory:")
+	defer database.Close()
+	logHandler := NewLogHandler(database, logrus.New())
+
+	from := common.HexToAddress("0x1234567890abcdef1234567890abcdef12345678")
+	to := common.HexToAddress("0x1234567890abcdef1234567890abcdef12345679")
+	log := types.Log{
+		Address:     common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"),
+		Topics:      []common.Hash{parser.TransferEventSigHash, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+		Data:        common.LeftPadBytes(big.NewInt(1000).Bytes(), 32),
+		BlockNumber: 12,
+		BlockHash:   common.HexToHash("0x0c"),
+		TxHash:      common.HexToHash("0x01"),
+		Index:       2,
+	}
+	// A replayed log is stored once
+	logHandler.HandleLog(context.Background(), log)
+	logHandler.HandleLog(context.Background(), log)
+
+	balance, err := database.GetBalance(log.Address.Hex(), to.Hex())
+	assert.NoError(t, err)
+	assert.Equal(t, "1000", balance.String())
+	checkpoint, _, err := database.GetCheckpoint(log.Address.Hex())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(12), checkpoint)
 }
@@ -0,0 +1,144 @@
+package loghandler
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// maxReorgDepth bounds how many indexed blocks are checked when looking for the fork point.
+const maxReorgDepth = 128
+
+// ChainReader is the subset of the Ethereum client used to detect and recover from reorgs.
+type ChainReader interface {
+	HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+}
+
+// handleRemovedLog rolls back the block of a log that was removed from the canonical chain.
+func (h *LogHandler) handleRemovedLog(vLog types.Log) {
+	stored, ok, err := h.DB.GetBlockHash(vLog.BlockNumber)
+	if err != nil {
+		h.Logger.Errorf("Failed to load block %d: %v", vLog.BlockNumber, err)
+		return
+	}
+	if !ok || stored != vLog.BlockHash.Hex() {
+		// The block was never indexed or has already been rolled back
+		return
+	}
+
+	h.Logger.Warnf("Log removed by reorg at block %d, rolling back", vLog.BlockNumber)
+	if err := h.DB.Rollback(vLog.BlockNumber); err != nil {
+		h.Logger.Errorf("Failed to roll back block %d: %v", vLog.BlockNumber, err)
+	}
+}
+
+// trackBlock records the block of a log. If the block replaces an indexed block, or its
+// ancestry does not match the indexed blocks, the orphaned blocks are rolled back and the
+// canonical branch is re-indexed first.
+func (h *LogHandler) trackBlock(ctx context.Context, vLog types.Log) error {
+	blockHash := vLog.BlockHash.Hex()
+	stored, ok, err := h.DB.GetBlockHash(vLog.BlockNumber)
+	if err != nil {
+		return err
+	}
+	if ok && stored == blockHash {
+		return nil
+	}
+	if ok {
+		h.Logger.Warnf("Block %d replaced by %s, rolling back", vLog.BlockNumber, blockHash)
+		if err := h.DB.Rollback(vLog.BlockNumber); err != nil {
+			return err
+		}
+	}
+
+	if h.Chain == nil {
+		return h.DB.SaveBlock(vLog.BlockNumber, blockHash, "")
+	}
+
+	header, err := h.Chain.HeaderByHash(ctx, vLog.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	forkPoint, reorged, err := h.findForkPoint(ctx, vLog.BlockNumber, header.ParentHash)
+	if err != nil {
+		return err
+	}
+	if reorged {
+		h.Logger.Warnf("Reorg detected at block %d, rolling back from block %d", vLog.BlockNumber, forkPoint)
+		if err := h.DB.Rollback(forkPoint); err != nil {
+			return err
+		}
+		if err := h.reindex(ctx, forkPoint, vLog.BlockNumber-1); err != nil {
+			return err
+		}
+	}
+
+	return h.DB.SaveBlock(vLog.BlockNumber, blockHash, header.ParentHash.Hex())
+}
+
+// findForkPoint walks back through the indexed blocks below the given block and returns
+// the first one that is no longer canonical, or false if the indexed chain is intact.
+func (h *LogHandler) findForkPoint(ctx context.Context, number uint64, parentHash common.Hash) (uint64, bool, error) {
+	forkPoint, reorged := number, false
+	for depth := 0; depth < maxReorgDepth; depth++ {
+		stored, storedHash, ok, err := h.DB.LatestBlockBefore(forkPoint)
+		if err != nil {
+			return 0, false, err
+		}
+		if !ok {
+			return forkPoint, reorged, nil
+		}
+
+		canonical := parentHash
+		if stored != number-1 {
+			header, err := h.Chain.HeaderByNumber(ctx, new(big.Int).SetUint64(stored))
+			if err != nil {
+				return 0, false, err
+			}
+			canonical = header.Hash()
+		}
+
+		if storedHash == canonical.Hex() {
+			return forkPoint, reorged, nil
+		}
+		forkPoint, reorged = stored, true
+	}
+
+	h.Logger.Errorf("Reorg deeper than %d indexed blocks, rolling back from block %d", maxReorgDepth, forkPoint)
+	return forkPoint, reorged, nil
+}
+
+// reindex fetches and stores the canonical logs for the blocks from..to (inclusive).
+func (h *LogHandler) reindex(ctx context.Context, from, to uint64) error {
+	if from > to {
+		return nil
+	}
+
+	logs, err := h.Chain.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(from),
+		ToBlock:   new(big.Int).SetUint64(to),
+		Addresses: h.Addresses,
+	})
+	if err != nil {
+		return err
+	}
+
+	h.Logger.Infof("Re-indexing %d logs from blocks %d-%d", len(logs), from, to)
+	for _, vLog := range logs {
+		if vLog.Removed {
+			continue
+		}
+		if err := h.DB.SaveBlock(vLog.BlockNumber, vLog.BlockHash.Hex(), ""); err != nil {
+			return err
+		}
+		h.processLog(ctx, vLog)
+	}
+
+	return nil
+}
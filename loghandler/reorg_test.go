@@ -0,0 +1,210 @@
+package loghandler
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"go-contract-indexer/db"
+	"go-contract-indexer/parser"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeChain is an in-memory chain whose canonical branch can be replaced to simulate reorgs
+type fakeChain struct {
+	finalized uint64
+	canonical map[uint64]*types.Header
+	headers   map[common.Hash]*types.Header
+	logs      map[common.Hash][]types.Log
+}
+
+func newFakeChain() *fakeChain {
+	return &fakeChain{
+		canonical: make(map[uint64]*types.Header),
+		headers:   make(map[common.Hash]*types.Header),
+		logs:      make(map[common.Hash][]types.Log),
+	}
+}
+
+// extend mines canonical blocks from the given number up to the given height on the named
+// branch, replacing any canonical blocks at those heights
+func (c *fakeChain) extend(from, to uint64, branch string) {
+	for number := from; number <= to; number++ {
+		header := &types.Header{Number: new(big.Int).SetUint64(number), Extra: []byte(branch)}
+		if parent, ok := c.canonical[number-1]; ok && number > 0 {
+			header.ParentHash = parent.Hash()
+		}
+		c.canonical[number] = header
+		c.headers[header.Hash()] = header
+	}
+	for number := range c.canonical {
+		if number > to {
+			delete(c.canonical, number)
+		}
+	}
+}
+
+// transfer adds a Transfer log to the canonical block at the given height and returns it
+func (c *fakeChain) transfer(number uint64) types.Log {
+	header := c.canonical[number]
+	vLog := types.Log{
+		Address:     common.HexToAddress("0x1234567890abcdef1234567890abcdef12345678"),
+		Topics:      []common.Hash{parser.TransferEventSigHash, common.HexToHash("0x01"), common.HexToHash("0x02")},
+		Data:        common.LeftPadBytes(big.NewInt(1).Bytes(), 32),
+		BlockNumber: number,
+		BlockHash:   header.Hash(),
+	}
+	c.logs[header.Hash()] = append(c.logs[header.Hash()], vLog)
+	return vLog
+}
+
+func (c *fakeChain) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	header, ok := c.headers[hash]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return header, nil
+}
+
+func (c *fakeChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	var height uint64
+	switch {
+	case number == nil:
+		for n := range c.canonical {
+			if n > height {
+				height = n
+			}
+		}
+	case number.Sign() < 0:
+		height = c.finalized
+	default:
+		height = number.Uint64()
+	}
+	header, ok := c.canonical[height]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return header, nil
+}
+
+func (c *fakeChain) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	var result []types.Log
+	for number := q.FromBlock.Uint64(); number <= q.ToBlock.Uint64(); number++ {
+		if header, ok := c.canonical[number]; ok {
+			result = append(result, c.logs[header.Hash()]...)
+		}
+	}
+	return result, nil
+}
+
+// eventBlocks returns the block numbers of the events stored in a database
+func eventBlocks(store *db.MemoryDB) []uint64 {
+	var blocks []uint64
+	for _, event := range store.Events() {
+		blocks = append(blocks, event.BlockNumber)
+	}
+	for _, event := range store.ContractEvents() {
+		blocks = append(blocks, event.BlockNumber)
+	}
+	for _, event := range store.NFTEvents() {
+		blocks = append(blocks, event.BlockNumber)
+	}
+	return blocks
+}
+
+// storedTransfers returns the ERC-20 transfers, mints and burns stored in a database
+func storedTransfers(store *db.MemoryDB) []db.Event {
+	var transfers []db.Event
+	for _, event := range store.Events() {
+		if event.EventType == "Transfer" || event.EventType == "Mint" || event.EventType == "Burn" {
+			transfers = append(transfers, event)
+		}
+	}
+	return transfers
+}
+
+// blockHash returns the hash a database stored for a block
+func blockHash(store *db.MemoryDB, blockNumber uint64) string {
+	hash, _, _ := store.GetBlockHash(blockNumber)
+	return hash
+}
+
+func TestReorg_ParentHashMismatch(t *testing.T) {
+	parser.Init()
+
+	chain := newFakeChain()
+	chain.extend(0, 5, "a")
+	store := db.NewMemoryDB()
+	logHandler := NewLogHandler(store, logrus.New())
+	logHandler.Chain = chain
+
+	ctx := context.Background()
+	logHandler.HandleLog(ctx, chain.transfer(2))
+	logHandler.HandleLog(ctx, chain.transfer(3))
+	assert.Equal(t, []uint64{2, 3}, eventBlocks(store))
+
+	// Replace blocks 3-6 with a new branch; the removed logs are never delivered
+	chain.extend(3, 6, "b")
+	chain.transfer(4)
+	logHandler.HandleLog(ctx, chain.transfer(6))
+
+	assert.Equal(t, []uint64{2, 4, 6}, eventBlocks(store), "Orphaned events should be replaced by the canonical branch")
+	assert.Equal(t, chain.canonical[4].Hash().Hex(), blockHash(store, 4))
+	assert.Equal(t, chain.canonical[6].Hash().Hex(), blockHash(store, 6))
+	_, orphaned, _ := store.GetBlockHash(3)
+	assert.False(t, orphaned, "Orphaned block should be deleted")
+}
+
+func TestReorg_RemovedLogs(t *testing.T) {
+	parser.Init()
+
+	chain := newFakeChain()
+	chain.extend(0, 4, "a")
+	store := db.NewMemoryDB()
+	logHandler := NewLogHandler(store, logrus.New())
+	logHandler.Chain = chain
+
+	ctx := context.Background()
+	logHandler.HandleLog(ctx, chain.transfer(2))
+	orphaned := chain.transfer(4)
+	logHandler.HandleLog(ctx, orphaned)
+
+	// The node reports the log as removed, then delivers the new branch
+	chain.extend(4, 5, "b")
+	orphaned.Removed = true
+	logHandler.HandleLog(ctx, orphaned)
+	assert.Equal(t, []uint64{2}, eventBlocks(store), "Removed log should be rolled back")
+
+	checkpoint, _, _ := store.GetCheckpoint(orphaned.Address.Hex())
+	assert.Equal(t, uint64(3), checkpoint)
+
+	logHandler.HandleLog(ctx, chain.transfer(5))
+	assert.Equal(t, []uint64{2, 5}, eventBlocks(store))
+
+	// A removed log for a block that is no longer indexed is ignored
+	logHandler.HandleLog(ctx, orphaned)
+	assert.Equal(t, []uint64{2, 5}, eventBlocks(store))
+}
+
+func TestReorg_ReplacedBlockWithoutChain(t *testing.T) {
+	parser.Init()
+
+	chain := newFakeChain()
+	chain.extend(0, 3, "a")
+	store := db.NewMemoryDB()
+	logHandler := NewLogHandler(store, logrus.New())
+
+	ctx := context.Background()
+	logHandler.HandleLog(ctx, chain.transfer(3))
+
+	chain.extend(3, 3, "b")
+	logHandler.HandleLog(ctx, chain.transfer(3))
+
+	assert.Equal(t, []uint64{3}, eventBlocks(store), "Events of the replaced block should be rolled back")
+	assert.Equal(t, chain.canonical[3].Hash().Hex(), blockHash(store, 3))
+}
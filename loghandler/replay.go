@@ -0,0 +1,42 @@
+package loghandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Replay handles the logs of a JSON fixture in order and returns the number of logs
+// handled. The fixture is an array of logs as returned by eth_getLogs, or a whole JSON-RPC
+// response holding one.
+func (h *LogHandler) Replay(ctx context.Context, r io.Reader) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var response struct {
+			Result json.RawMessage `json:"result"`
+		}
+		if err := json.Unmarshal(trimmed, &response); err != nil {
+			return 0, fmt.Errorf("failed to decode JSON-RPC response: %v", err)
+		}
+		data = response.Result
+	}
+
+	var logs []types.Log
+	if err := json.Unmarshal(data, &logs); err != nil {
+		return 0, fmt.Errorf("failed to decode logs: %v", err)
+	}
+	for _, vLog := range logs {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		h.HandleLog(ctx, vLog)
+	}
+	return len(logs), nil
+}
@@ -0,0 +1,53 @@
+package loghandler
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"go-contract-indexer/db"
+	"go-contract-indexer/parser"
+)
+
+func TestReplay(t *testing.T) {
+	parser.Init()
+
+	store := db.NewMemoryDB()
+	logHandler := NewLogHandler(store, logrus.New())
+
+	for i := 0; i < 2; i++ {
+		fixture, err := os.Open("testdata/transfers.json")
+		assert.NoError(t, err)
+		n, err := logHandler.Replay(context.Background(), fixture)
+		fixture.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, 4, n)
+	}
+
+	usdc := common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48").Hex()
+	alice := common.HexToAddress("0x1234567890abcdef1234567890abcdef12345678").Hex()
+	bob := common.HexToAddress("0x1234567890abcdef1234567890abcdef12345679").Hex()
+
+	assert.Len(t, store.Events(), 4, "Replaying a fixture twice should store its logs once")
+	balances := store.Balances(usdc)
+	assert.Equal(t, "750", balances[alice].String())
+	assert.Equal(t, "200", balances[bob].String())
+	allowance, err := store.GetAllowance(usdc, alice, bob)
+	assert.NoError(t, err)
+	assert.Equal(t, "500", allowance.String())
+	_, supply, _, err := store.GetTotalSupply(usdc)
+	assert.NoError(t, err)
+	assert.Equal(t, "950", supply.String())
+	assert.Equal(t, map[string]uint64{usdc: 102}, store.Checkpoints())
+}
+
+func TestReplay_Invalid(t *testing.T) {
+	logHandler := NewLogHandler(db.NewMemoryDB(), logrus.New())
+	_, err := logHandler.Replay(context.Background(), strings.NewReader(`[{"address": "0x01"}]`))
+	assert.Error(t, err, "Logs without topics and data should be rejected")
+}
@@ -2,11 +2,12 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"math/big"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
@@ -16,10 +17,14 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 
+	"go-contract-indexer/api"
 	"go-contract-indexer/db"
 	"go-contract-indexer/erc20"
+	"go-contract-indexer/ingest"
 	"go-contract-indexer/loghandler"
+	"go-contract-indexer/metadata"
 	"go-contract-indexer/parser"
+	"go-contract-indexer/verify"
 )
 
 var logger = logrus.New()
@@ -40,104 +45,305 @@ func init() {
 	logger.Level = logrus.DebugLevel
 }
 
-func validateConfig() error {
-	if viper.GetString("RPC_URL") == "" {
-		return errors.New("RPC_URL is required")
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			logger.Fatalf("Migration failed: %v", err)
+		}
+		return
 	}
-	if viper.GetString("CONTRACT_ADDRESS") == "" {
-		return errors.New("CONTRACT_ADDRESS is required")
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		if err := runSnapshot(os.Args[2:]); err != nil {
+			logger.Fatalf("Snapshot failed: %v", err)
+		}
+		return
 	}
-	if viper.GetString("DB_CONN_STR") == "" {
-		return errors.New("DB_CONN_STR is required")
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := runReplay(os.Args[2:]); err != nil {
+			logger.Fatalf("Replay failed: %v", err)
+		}
+		return
 	}
-	return nil
-}
-
-func main() {
 	if err := validateConfig(); err != nil {
 		logger.Fatalf("Configuration validation failed: %v", err)
 	}
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		if err := runVerify(os.Args[2:]); err != nil {
+			logger.Fatalf("Verification failed: %v", err)
+		}
+		return
+	}
 	// Load configuration
-	rpcURL := viper.GetString("RPC_URL")
-	contractAddress := viper.GetString("CONTRACT_ADDRESS")
 	dbConnStr := viper.GetString("DB_CONN_STR")
-
-	if rpcURL == "" || contractAddress == "" || dbConnStr == "" {
-		logger.Fatal("RPC_URL, CONTRACT_ADDRESS, or DB_CONN_STR is not set in the configuration")
+	contracts, err := loadContracts()
+	if err != nil {
+		logger.Fatalf("Invalid contracts: %v", err)
 	}
 
 	// Initialize the database connection with retry mechanism
-	database := db.InitDB(dbConnStr)
+	store := db.InitDB(dbConnStr)
+	var database db.Interface = store
+	if size := viper.GetInt("BATCH_SIZE"); size > 1 {
+		database = db.NewBatchWriter(database, size, viper.GetDuration("BATCH_FLUSH_INTERVAL"))
+	}
 	defer database.Close()
 
 	// Initialize the ABI
 	parser.Init()
 
-	// Connect to the Ethereum client
-	client, err := ethclient.Dial(rpcURL)
+	// Connect to the Ethereum clients
+	endpoints, err := loadEndpoints()
+	if err != nil {
+		logger.Fatalf("Invalid RPC endpoints: %v", err)
+	}
+	failover, err := dialEndpoints(endpoints)
 	if err != nil {
 		logger.Fatalf("Failed to connect to the Ethereum client: %v", err)
 	}
+	failover.MaxLag = viper.GetUint64("RPC_MAX_LAG")
+	failover.CrossCheck = viper.GetBool("RPC_CROSS_CHECK")
 
-	contractAddr := common.HexToAddress(contractAddress)
-
-	// Subscribe to the logs of the contract
-	query := ethereum.FilterQuery{
-		Addresses: []common.Address{contractAddr},
+	// Subscribe to the logs of the contracts, backfilling each from its start block
+	head, err := failover.BlockNumber(context.Background())
+	if err != nil {
+		logger.Fatalf("Failed to fetch the chain head: %v", err)
 	}
-
-	logs := make(chan types.Log)
-	sub, err := client.SubscribeFilterLogs(context.Background(), query, logs)
+	startBlocks, err := resolveStartBlocks(database, contracts, head)
 	if err != nil {
-		logger.Fatalf("Failed to subscribe to logs: %v", err)
+		logger.Fatalf("Failed to read sync checkpoints: %v", err)
+	}
+
+	query := ethereum.FilterQuery{}
+	fromBlock := head + 1
+	for address, startBlock := range startBlocks {
+		query.Addresses = append(query.Addresses, address)
+		if startBlock < fromBlock {
+			fromBlock = startBlock
+		}
+	}
+	query.FromBlock = new(big.Int).SetUint64(fromBlock)
+	if fromBlock <= head {
+		logger.Infof("Backfilling events of %d contracts from block %d", len(contracts), fromBlock)
 	}
 
 	// Handle graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+
+	// Keep checking the endpoints so lagging or failing ones are demoted
+	go failover.Run(ctx, viper.GetDuration("RPC_HEALTH_INTERVAL"))
+
+	// Stream the logs, failing over to the healthiest endpoint and backfilling missed
+	// blocks if the connection drops
+	reconnector := &ingest.Reconnector{
+		Dial: func(ctx context.Context) (ingest.Client, error) {
+			if err := failover.HealthCheck(ctx); err != nil {
+				return nil, err
+			}
+			return failover, nil
+		},
+		Logger:       logger,
+		Query:        query,
+		BatchSize:    viper.GetUint64("BACKFILL_BATCH_SIZE"),
+		Polling:      requiresPolling(endpoints),
+		PollInterval: viper.GetDuration("POLL_INTERVAL"),
+		MaxBackoff:   viper.GetDuration("RECONNECT_MAX_BACKOFF"),
+	}
+	switch {
+	case reconnector.Polling:
+		logger.Info("RPC endpoints do not support subscriptions, polling for logs")
+	case failover.CrossCheck:
+		// Live subscription logs come from a single endpoint, polled ranges are cross-checked
+		reconnector.Polling = true
+		logger.Info("Cross-checking RPC endpoints, polling for logs")
+	}
+
+	logs := make(chan types.Log)
+	sub, err := reconnector.Subscribe(ctx, logs)
+	if err != nil {
+		logger.Fatalf("Failed to subscribe to logs: %v", err)
+	}
 	go handleShutdown(cancel, sub)
 
 	// Print contract details
-	err = printTokenInfo(client, contractAddr)
-	if err != nil {
-		logger.Fatalf("Error: %v", err)
+	for _, c := range contracts {
+		err = printTokenInfo(failover, database, common.HexToAddress(c.Address), c.Label)
+		if err != nil {
+			logger.Fatalf("Error: %v", err)
+		}
+	}
+
+	// Keep the token metadata up to date
+	go metadata.Run(ctx, failover, database, query.Addresses, logger, viper.GetDuration("TOKEN_REFRESH_INTERVAL"))
+
+	// Serve the indexed events and balances over HTTP
+	if addr := viper.GetString("API_ADDR"); addr != "" {
+		contractAddresses := make([]string, len(query.Addresses))
+		for i, address := range query.Addresses {
+			contractAddresses[i] = address.Hex()
+		}
+		// The API reads the store directly, so requests never flush a pending batch
+		server := api.NewServer(store, contractAddresses, logger)
+		go func() {
+			if err := server.Run(ctx, addr); err != nil {
+				logger.Errorf("API server stopped: %v", err)
+			}
+		}()
+		logger.Infof("Serving the API on %s", addr)
+	}
+
+	// Compare the tracked total supplies with the chain
+	if interval := viper.GetDuration("SUPPLY_CHECK_INTERVAL"); interval > 0 {
+		go watchSupply(ctx, failover, database, contracts, interval)
 	}
 
 	// Create LogHandler instance
 	logHandler := loghandler.NewLogHandler(database, logger)
+	logHandler.Chain = failover
+	logHandler.Addresses = query.Addresses
+	logHandler.StartBlocks = startBlocks
+	logHandler.Confirmations = viper.GetUint64("CONFIRMATIONS")
+	logHandler.Finality = viper.GetString("FINALITY")
+	if viper.GetBool("TRACK_TRANSFER_FROM") {
+		logHandler.Transactions = failover
+	}
 
 	// Handle incoming logs
-	logHandler.HandleLogs(ctx, logs, sub)
+	if err := logHandler.HandleLogs(ctx, logs, sub); err != nil {
+		logger.Fatalf("Log handling stopped: %v", err)
+	}
+	logger.Infof("Log stream reconnected %d times", reconnector.Reconnects())
 }
 
-// printTokenInfo prints the token information for the given contract address.
-func printTokenInfo(client *ethclient.Client, contractAddr common.Address) error {
-	token, err := erc20.NewErc20(contractAddr, client)
-	if err != nil {
-		return fmt.Errorf("failed to instantiate token contract: %v", err)
+// dialEndpoints connects to the endpoints and returns them behind a failover. Endpoints
+// that cannot be dialed are skipped, so the indexer starts as long as any endpoint is
+// reachable.
+func dialEndpoints(endpoints []endpointConfig) (*ingest.Failover, error) {
+	var dialed []*ingest.Endpoint
+	var lastErr error
+	for _, e := range endpoints {
+		client, err := ethclient.Dial(e.URL)
+		if err != nil {
+			logger.Warnf("Skipping RPC endpoint %s: %v", e.URL, err)
+			lastErr = fmt.Errorf("failed to dial %s: %v", e.URL, err)
+			continue
+		}
+		dialed = append(dialed, &ingest.Endpoint{URL: e.URL, Priority: e.Priority, Backend: client})
 	}
-
-	callOpts := &bind.CallOpts{}
-
-	name, err := token.Name(callOpts)
-	if err != nil {
-		return fmt.Errorf("failed to get token name: %v", err)
+	if len(dialed) == 0 {
+		return nil, fmt.Errorf("no RPC endpoint could be dialed: %v", lastErr)
 	}
+	return ingest.NewFailover(dialed, logger), nil
+}
 
-	symbol, err := token.Symbol(callOpts)
+// printTokenInfo fetches, stores and prints the metadata of the given contract address.
+func printTokenInfo(client bind.ContractCaller, database db.Interface, contractAddr common.Address, label string) error {
+	token, err := metadata.Fetch(context.Background(), client, contractAddr)
 	if err != nil {
-		return fmt.Errorf("failed to get token symbol: %v", err)
+		return fmt.Errorf("failed to get token metadata: %v", err)
+	}
+	if err := database.SaveToken(token); err != nil {
+		return fmt.Errorf("failed to save token metadata: %v", err)
 	}
 
-	logger.WithFields(logrus.Fields{
+	fields := logrus.Fields{
 		"address": contractAddr.Hex(),
-		"name":    name,
-		"symbol":  symbol,
-	}).Info("Starting indexer for ERC-20 contract")
+		"label":   label,
+	}
+	if token.Name != nil {
+		fields["name"] = *token.Name
+	}
+	if token.Symbol != nil {
+		fields["symbol"] = *token.Symbol
+	}
+	if token.Decimals != nil {
+		fields["decimals"] = *token.Decimals
+	}
+	logger.WithFields(fields).Info("Starting indexer for token contract")
 
 	return nil
 }
 
+// watchSupply periodically compares the total supply tracked from mints and burns with
+// totalSupply on chain, logging any difference. Contracts not indexed from their
+// deployment are skipped, as their tracked supply misses the earlier mints and burns.
+func watchSupply(ctx context.Context, client bind.ContractCaller, database db.Interface, contracts []contractConfig, interval time.Duration) {
+	var checked []contractConfig
+	for _, c := range contracts {
+		ok, err := indexedFromDeployment(ctx, client, c)
+		if err != nil {
+			logger.Warnf("Skipping total supply checks of %s: %v", c.Address, err)
+			continue
+		}
+		if !ok {
+			logger.Infof("Skipping total supply checks of %s, it is not indexed from its deployment", c.Address)
+			continue
+		}
+		checked = append(checked, c)
+	}
+	if len(checked) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		for _, c := range checked {
+			address := common.HexToAddress(c.Address)
+			block, supply, ok, err := database.GetTotalSupply(address.Hex())
+			if err != nil {
+				logger.Errorf("Failed to read the total supply of %s: %v", address.Hex(), err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+
+			caller, err := erc20.NewErc20Caller(address, client)
+			if err != nil {
+				logger.Errorf("Failed to instantiate token contract: %v", err)
+				continue
+			}
+			onChain, ok, err := verify.CheckSupply(ctx, caller, supply, block)
+			if err != nil {
+				logger.Warnf("Failed to check the total supply of %s: %v", address.Hex(), err)
+				continue
+			}
+			if !ok {
+				logger.WithFields(logrus.Fields{
+					"address": address.Hex(),
+					"block":   block,
+					"indexed": supply.String(),
+					"onchain": onChain.String(),
+				}).Warn("Total supply mismatch")
+			}
+		}
+	}
+}
+
+// indexedFromDeployment reports whether a contract is indexed from the block it was
+// deployed in, that is it has a start block and no code in the block before it.
+func indexedFromDeployment(ctx context.Context, client bind.ContractCaller, c contractConfig) (bool, error) {
+	if c.StartBlock == nil {
+		return false, nil
+	}
+	if *c.StartBlock == 0 {
+		return true, nil
+	}
+	code, err := client.CodeAt(ctx, common.HexToAddress(c.Address), new(big.Int).SetUint64(*c.StartBlock-1))
+	if err != nil {
+		return false, fmt.Errorf("failed to get the code before block %d: %v", *c.StartBlock, err)
+	}
+	return len(code) == 0, nil
+}
+
 // handleShutdown handles graceful shutdown on receiving a termination signal.
 func handleShutdown(cancel context.CancelFunc, sub ethereum.Subscription) {
 	sigCh := make(chan os.Signal, 1)
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"math/big"
 	"testing"
 
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+
+	"go-contract-indexer/db"
 )
 
 // MockSubscription is a mock of ethereum.Subscription interface
@@ -22,6 +28,21 @@ func (m *MockSubscription) Err() <-chan error {
 	return args.Get(0).(<-chan error)
 }
 
+// MockContractCaller is a mock of the bind.ContractCaller interface
+type MockContractCaller struct {
+	mock.Mock
+}
+
+func (m *MockContractCaller) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	args := m.Called(contract, blockNumber.Uint64())
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockContractCaller) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	args := m.Called(call, blockNumber)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
 func initTestConfig() {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
@@ -56,3 +77,101 @@ func TestLoadConfig_Error(t *testing.T) {
 	assert.Empty(t, contractAddress, "CONTRACT_ADDRESS should be empty")
 	assert.Empty(t, dbConnStr, "DB_CONN_STR should be empty")
 }
+
+func TestLoadContracts(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	// CONTRACT_ADDRESS and START_BLOCK are used when no list is configured
+	viper.Set("CONTRACT_ADDRESS", "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	viper.Set("START_BLOCK", 100)
+	contracts, err := loadContracts()
+	assert.NoError(t, err)
+	assert.Len(t, contracts, 1)
+	assert.Equal(t, uint64(100), *contracts[0].StartBlock)
+
+	viper.Set("CONTRACTS", []map[string]interface{}{
+		{"address": "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", "label": "USDC", "start_block": 6082465},
+		{"address": "0x6B175474E89094C44Da98b954EedeAC495271d0F", "label": "DAI"},
+	})
+	contracts, err = loadContracts()
+	assert.NoError(t, err)
+	assert.Len(t, contracts, 2)
+	assert.Equal(t, "USDC", contracts[0].Label)
+	assert.Equal(t, uint64(6082465), *contracts[0].StartBlock)
+	assert.Nil(t, contracts[1].StartBlock)
+
+	viper.Set("CONTRACTS", []map[string]interface{}{{"address": "0xinvalid"}})
+	_, err = loadContracts()
+	assert.Error(t, err, "Invalid addresses should be rejected")
+}
+
+func TestResolveStartBlocks(t *testing.T) {
+	usdc := common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	dai := common.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F")
+	weth := common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+	startBlock := uint64(100)
+
+	store := db.NewMemoryDB()
+	assert.NoError(t, store.SaveContractEvent(db.ContractEvent{ContractAddress: usdc.Hex(), BlockNumber: 500}))
+
+	startBlocks, err := resolveStartBlocks(store, []contractConfig{
+		{Address: usdc.Hex(), StartBlock: &startBlock},
+		{Address: dai.Hex(), StartBlock: &startBlock},
+		{Address: weth.Hex()},
+	}, 1000)
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint64(500), startBlocks[usdc], "The checkpoint block should be indexed again and take precedence over the start block")
+	assert.Equal(t, uint64(100), startBlocks[dai], "The start block should be used without a checkpoint")
+	assert.Equal(t, uint64(1001), startBlocks[weth], "Contracts without a start block should only be indexed live")
+}
+
+func TestIndexedFromDeployment(t *testing.T) {
+	usdc := common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	dai := common.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F")
+	deployment, later := uint64(100), uint64(500)
+
+	caller := new(MockContractCaller)
+	caller.On("CodeAt", usdc, uint64(99)).Return([]byte{}, nil)
+	caller.On("CodeAt", dai, uint64(499)).Return([]byte{0x60, 0x80}, nil)
+
+	ok, err := indexedFromDeployment(context.Background(), caller, contractConfig{Address: usdc.Hex(), StartBlock: &deployment})
+	assert.NoError(t, err)
+	assert.True(t, ok, "Contracts without code before the start block are indexed from deployment")
+
+	ok, err = indexedFromDeployment(context.Background(), caller, contractConfig{Address: dai.Hex(), StartBlock: &later})
+	assert.NoError(t, err)
+	assert.False(t, ok, "Contracts with code before the start block miss earlier mints and burns")
+
+	ok, err = indexedFromDeployment(context.Background(), caller, contractConfig{Address: dai.Hex()})
+	assert.NoError(t, err)
+	assert.False(t, ok, "Contracts indexed only live miss earlier mints and burns")
+}
+
+func TestLoadEndpoints(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	// RPC_URL is used as the only endpoint when no list is configured
+	viper.Set("RPC_URL", "wss://mainnet.example.com")
+	endpoints, err := loadEndpoints()
+	assert.NoError(t, err)
+	assert.Equal(t, []endpointConfig{{URL: "wss://mainnet.example.com"}}, endpoints)
+
+	viper.Set("RPC_ENDPOINTS", []map[string]interface{}{
+		{"url": "wss://primary.example.com", "priority": 1},
+		{"url": "https://backup.example.com", "priority": 2},
+	})
+	endpoints, err = loadEndpoints()
+	assert.NoError(t, err)
+	assert.Equal(t, []endpointConfig{
+		{URL: "wss://primary.example.com", Priority: 1},
+		{URL: "https://backup.example.com", Priority: 2},
+	}, endpoints)
+	assert.False(t, requiresPolling(endpoints), "Subscriptions should be used while any endpoint supports them")
+
+	viper.Set("RPC_ENDPOINTS", []map[string]interface{}{{"priority": 1}})
+	_, err = loadEndpoints()
+	assert.Error(t, err, "Endpoints without a url should be rejected")
+}
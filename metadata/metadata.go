@@ -0,0 +1,124 @@
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+
+	"go-contract-indexer/db"
+	"go-contract-indexer/erc20"
+)
+
+// DefaultRefreshInterval is how often token metadata is refreshed when no interval is configured.
+const DefaultRefreshInterval = 24 * time.Hour
+
+// Fetch reads the name, symbol and decimals of a token through the erc20 binding. Tokens
+// that predate the final ERC-20 interface and return their name or symbol as bytes32 are
+// supported, and fields whose call reverts or is not implemented are left nil. Other
+// errors, such as failing RPC requests, are returned so stale metadata is kept.
+func Fetch(ctx context.Context, backend bind.ContractCaller, address common.Address) (db.Token, error) {
+	token := db.Token{ContractAddress: address.Hex()}
+	caller, err := erc20.NewErc20Caller(address, backend)
+	if err != nil {
+		return token, fmt.Errorf("failed to instantiate token contract: %v", err)
+	}
+	opts := &bind.CallOpts{Context: ctx}
+
+	if token.Name, err = fetchString(ctx, backend, address, "name", caller.Name); err != nil {
+		return token, err
+	}
+	if token.Symbol, err = fetchString(ctx, backend, address, "symbol", caller.Symbol); err != nil {
+		return token, err
+	}
+
+	decimals, err := caller.Decimals(opts)
+	switch {
+	case err == nil:
+		token.Decimals = &decimals
+	case !unsupported(err):
+		return token, fmt.Errorf("failed to get token decimals: %v", err)
+	}
+
+	return token, nil
+}
+
+// fetchString calls a string getter of the binding, falling back to decoding a bytes32
+// return value when the output cannot be unpacked as a string.
+func fetchString(ctx context.Context, backend bind.ContractCaller, address common.Address, method string, call func(*bind.CallOpts) (string, error)) (*string, error) {
+	value, err := call(&bind.CallOpts{Context: ctx})
+	if err == nil {
+		return &value, nil
+	}
+	if unsupported(err) {
+		return nil, nil
+	}
+
+	parsed, abiErr := erc20.Erc20MetaData.GetAbi()
+	if abiErr != nil {
+		return nil, abiErr
+	}
+	input, abiErr := parsed.Pack(method)
+	if abiErr != nil {
+		return nil, abiErr
+	}
+	output, callErr := backend.CallContract(ctx, ethereum.CallMsg{To: &address, Data: input}, nil)
+	switch {
+	case callErr != nil && unsupported(callErr):
+		return nil, nil
+	case callErr != nil:
+		return nil, fmt.Errorf("failed to get token %s: %v", method, callErr)
+	case len(output) == 0:
+		return nil, nil
+	case len(output) == 32:
+		value = string(bytes.TrimRight(output, "\x00"))
+		return &value, nil
+	default:
+		return nil, fmt.Errorf("failed to get token %s: %v", method, err)
+	}
+}
+
+// unsupported reports whether a call failed because the contract does not implement
+// the method, rather than because of the connection to the node.
+func unsupported(err error) bool {
+	return errors.Is(err, bind.ErrNoCode) || strings.Contains(err.Error(), "execution reverted")
+}
+
+// Refresh fetches and stores the metadata of every token, logging those that fail.
+func Refresh(ctx context.Context, backend bind.ContractCaller, database db.Interface, addresses []common.Address, logger logrus.FieldLogger) {
+	for _, address := range addresses {
+		token, err := Fetch(ctx, backend, address)
+		if err != nil {
+			logger.Warnf("Failed to fetch the metadata of %s: %v", address.Hex(), err)
+			continue
+		}
+		if err := database.SaveToken(token); err != nil {
+			logger.Errorf("Failed to save the metadata of %s: %v", address.Hex(), err)
+		}
+	}
+}
+
+// Run refreshes the metadata of the tokens every interval until the context is cancelled.
+func Run(ctx context.Context, backend bind.ContractCaller, database db.Interface, addresses []common.Address, logger logrus.FieldLogger, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			Refresh(ctx, backend, database, addresses, logger)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
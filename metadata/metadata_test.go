@@ -0,0 +1,118 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"go-contract-indexer/db"
+	"go-contract-indexer/erc20"
+)
+
+var errReverted = errors.New("execution reverted")
+
+// fakeCaller answers calls by method name with raw outputs or errors
+type fakeCaller struct {
+	outputs map[string][]byte
+	errs    map[string]error
+}
+
+func (c *fakeCaller) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return []byte{0x60}, nil
+}
+
+func (c *fakeCaller) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	parsed, err := erc20.Erc20MetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	method, err := parsed.MethodById(call.Data[:4])
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := c.errs[method.Name]; ok {
+		return nil, err
+	}
+	return c.outputs[method.Name], nil
+}
+
+// pack encodes the outputs of an erc20 method
+func pack(t *testing.T, method string, value interface{}) []byte {
+	parsed, err := erc20.Erc20MetaData.GetAbi()
+	assert.NoError(t, err)
+	output, err := parsed.Methods[method].Outputs.Pack(value)
+	assert.NoError(t, err)
+	return output
+}
+
+func TestFetch(t *testing.T) {
+	caller := &fakeCaller{outputs: map[string][]byte{
+		"name":     pack(t, "name", "USD Coin"),
+		"symbol":   pack(t, "symbol", "USDC"),
+		"decimals": pack(t, "decimals", uint8(6)),
+	}}
+
+	token, err := Fetch(context.Background(), caller, common.HexToAddress("0x01"))
+	assert.NoError(t, err)
+	assert.Equal(t, "USD Coin", *token.Name)
+	assert.Equal(t, "USDC", *token.Symbol)
+	assert.Equal(t, uint8(6), *token.Decimals)
+}
+
+func TestFetch_NonStandard(t *testing.T) {
+	// Early tokens such as MKR return their name and symbol as bytes32
+	caller := &fakeCaller{
+		outputs: map[string][]byte{
+			"name":   common.RightPadBytes([]byte("Maker"), 32),
+			"symbol": common.RightPadBytes([]byte("MKR"), 32),
+		},
+		errs: map[string]error{"decimals": errReverted},
+	}
+
+	token, err := Fetch(context.Background(), caller, common.HexToAddress("0x01"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Maker", *token.Name)
+	assert.Equal(t, "MKR", *token.Symbol)
+	assert.Nil(t, token.Decimals, "Reverting decimals should be left unset")
+}
+
+func TestFetch_Error(t *testing.T) {
+	caller := &fakeCaller{errs: map[string]error{"name": errors.New("connection refused")}}
+
+	_, err := Fetch(context.Background(), caller, common.HexToAddress("0x01"))
+	assert.ErrorContains(t, err, "connection refused", "Connection errors should not be mistaken for missing metadata")
+}
+
+// fakeDB records the saved tokens
+type fakeDB struct {
+	db.Interface
+	tokens []db.Token
+}
+
+func (d *fakeDB) SaveToken(token db.Token) error {
+	d.tokens = append(d.tokens, token)
+	return nil
+}
+
+func TestRefresh(t *testing.T) {
+	caller := &fakeCaller{
+		outputs: map[string][]byte{"symbol": pack(t, "symbol", "BAYC")},
+		errs:    map[string]error{"name": errReverted, "decimals": errReverted},
+	}
+	database := &fakeDB{}
+
+	Refresh(context.Background(), caller, database, []common.Address{common.HexToAddress("0x01")}, logrus.New())
+	assert.Len(t, database.tokens, 1)
+	assert.Nil(t, database.tokens[0].Name)
+	assert.Equal(t, "BAYC", *database.tokens[0].Symbol)
+
+	caller.errs["symbol"] = errors.New("timeout")
+	Refresh(context.Background(), caller, database, []common.Address{common.HexToAddress("0x01")}, logrus.New())
+	assert.Len(t, database.tokens, 1, "Metadata should not be overwritten when fetching fails")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"go-contract-indexer/db"
+)
+
+// runMigrate applies, reverts or lists the schema migrations of the database at DB_CONN_STR.
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down|status")
+	}
+	if viper.GetString("DB_CONN_STR") == "" {
+		return errors.New("DB_CONN_STR is required")
+	}
+
+	database, err := db.Open(viper.GetString("DB_CONN_STR"))
+	if err != nil {
+		return fmt.Errorf("could not connect to the database: %v", err)
+	}
+	defer database.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := database.MigrateUp()
+		if err != nil {
+			return err
+		}
+		logger.Infof("Applied %d migrations", len(applied))
+	case "down":
+		flags := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		steps := flags.Int("steps", 1, "number of migrations to revert")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		reverted, err := database.MigrateDown(*steps)
+		if err != nil {
+			return err
+		}
+		logger.Infof("Reverted %d migrations", len(reverted))
+	case "status":
+		statuses, err := database.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			fields := logrus.Fields{"version": s.Version, "name": s.Name, "applied": s.Applied}
+			if s.Applied {
+				fields["applied_at"] = s.AppliedAt
+			}
+			logger.WithFields(fields).Info("Migration")
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package parser
 
 import (
 	"bytes"
+	"fmt"
 	"log"
+	"math/big"
 	"os"
 	"sync"
 
@@ -17,6 +19,21 @@ var (
 	once      sync.Once
 )
 
+// Layouts of the data of the ERC-1155 events with dynamic arguments
+var (
+	transferBatchData = abi.Arguments{{Type: mustNewType("uint256[]")}, {Type: mustNewType("uint256[]")}}
+	uriData           = abi.Arguments{{Type: mustNewType("string")}}
+)
+
+// mustNewType creates an ABI type, panicking on invalid type names.
+func mustNewType(name string) abi.Type {
+	t, err := abi.NewType(name, "", nil)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
 // Load the ERC-20 ABI from file
 func loadABI() {
 	abiPath := os.Getenv("ERC20_ABI_PATH")
@@ -49,28 +66,124 @@ func SetABI(mockedABI string) {
 	}
 }
 
-// UnpackLog unpacks logs into their respective events.
+// UnpackLog unpacks logs into their respective events. Transfer and Approval events are
+// unpacked into their ERC-20 or ERC-721 types depending on the detected token standard,
+// ERC-1155 events into their own types, and other events of the loaded ABI into a
+// DecodedEvent. Logs without topics return ErrAnonymousLog, and logs that do not match
+// the layout of their event return a *DecodeError.
 func UnpackLog(log types.Log) (interface{}, error) {
+	if len(log.Topics) == 0 {
+		return nil, ErrAnonymousLog
+	}
+
 	switch log.Topics[0] {
 	case TransferEventSigHash:
+		if DetectStandard(log) == StandardERC721 {
+			if err := checkData("Transfer", log.Data, 0); err != nil {
+				return nil, err
+			}
+			return &ERC721Transfer{
+				From:    common.BytesToAddress(log.Topics[1].Bytes()),
+				To:      common.BytesToAddress(log.Topics[2].Bytes()),
+				TokenID: log.Topics[3].Big(),
+			}, nil
+		}
+		if err := checkTopics("Transfer", len(log.Topics), 3); err != nil {
+			return nil, err
+		}
 		event := new(ERC20Transfer)
 		err := ParsedABI.UnpackIntoInterface(event, "Transfer", log.Data)
 		if err != nil {
-			return nil, err
+			return nil, &DecodeError{Event: "Transfer", Reason: err.Error()}
 		}
 		event.From = common.HexToAddress(log.Topics[1].Hex())
 		event.To = common.HexToAddress(log.Topics[2].Hex())
 		return event, nil
 	case ApprovalEventSigHash:
+		if DetectStandard(log) == StandardERC721 {
+			if err := checkData("Approval", log.Data, 0); err != nil {
+				return nil, err
+			}
+			return &ERC721Approval{
+				Owner:    common.BytesToAddress(log.Topics[1].Bytes()),
+				Approved: common.BytesToAddress(log.Topics[2].Bytes()),
+				TokenID:  log.Topics[3].Big(),
+			}, nil
+		}
+		if err := checkTopics("Approval", len(log.Topics), 3); err != nil {
+			return nil, err
+		}
 		event := new(ERC20Approval)
 		err := ParsedABI.UnpackIntoInterface(event, "Approval", log.Data)
 		if err != nil {
-			return nil, err
+			return nil, &DecodeError{Event: "Approval", Reason: err.Error()}
 		}
 		event.Owner = common.HexToAddress(log.Topics[1].Hex())
 		event.Spender = common.HexToAddress(log.Topics[2].Hex())
 		return event, nil
+	case ApprovalForAllEventSigHash:
+		if err := checkTopics("ApprovalForAll", len(log.Topics), 3); err != nil {
+			return nil, err
+		}
+		if err := checkData("ApprovalForAll", log.Data, 32); err != nil {
+			return nil, err
+		}
+		approved := new(big.Int).SetBytes(log.Data)
+		if approved.BitLen() > 1 {
+			return nil, &DecodeError{Event: "ApprovalForAll", Reason: "invalid boolean " + approved.String()}
+		}
+		return &ApprovalForAll{
+			Owner:    common.BytesToAddress(log.Topics[1].Bytes()),
+			Operator: common.BytesToAddress(log.Topics[2].Bytes()),
+			Approved: approved.Sign() == 1,
+		}, nil
+	case TransferSingleEventSigHash:
+		if err := checkTopics("TransferSingle", len(log.Topics), 4); err != nil {
+			return nil, err
+		}
+		if err := checkData("TransferSingle", log.Data, 64); err != nil {
+			return nil, err
+		}
+		return &ERC1155TransferSingle{
+			Operator: common.BytesToAddress(log.Topics[1].Bytes()),
+			From:     common.BytesToAddress(log.Topics[2].Bytes()),
+			To:       common.BytesToAddress(log.Topics[3].Bytes()),
+			ID:       new(big.Int).SetBytes(log.Data[:32]),
+			Value:    new(big.Int).SetBytes(log.Data[32:]),
+		}, nil
+	case TransferBatchEventSigHash:
+		if err := checkTopics("TransferBatch", len(log.Topics), 4); err != nil {
+			return nil, err
+		}
+		values, err := transferBatchData.Unpack(log.Data)
+		if err != nil {
+			return nil, &DecodeError{Event: "TransferBatch", Reason: err.Error()}
+		}
+		event := &ERC1155TransferBatch{
+			Operator: common.BytesToAddress(log.Topics[1].Bytes()),
+			From:     common.BytesToAddress(log.Topics[2].Bytes()),
+			To:       common.BytesToAddress(log.Topics[3].Bytes()),
+			IDs:      values[0].([]*big.Int),
+			Values:   values[1].([]*big.Int),
+		}
+		if len(event.IDs) != len(event.Values) {
+			return nil, &DecodeError{Event: "TransferBatch", Reason: fmt.Sprintf("%d ids for %d values", len(event.IDs), len(event.Values))}
+		}
+		return event, nil
+	case URIEventSigHash:
+		if err := checkTopics("URI", len(log.Topics), 2); err != nil {
+			return nil, err
+		}
+		values, err := uriData.Unpack(log.Data)
+		if err != nil {
+			return nil, &DecodeError{Event: "URI", Reason: err.Error()}
+		}
+		return &ERC1155URI{Value: values[0].(string), ID: log.Topics[1].Big()}, nil
 	default:
-		return nil, nil
+		// Any other event of the loaded ABI is decoded generically
+		if _, err := ParsedABI.EventByID(log.Topics[0]); err != nil {
+			return nil, nil
+		}
+		return DecodeLog(log)
 	}
 }
@@ -58,6 +58,161 @@ func TestUnpackLog(t *testing.T) {
 	assert.Equal(t, big.NewInt(500), approval.Value)
 }
 
+func TestUnpackLog_ERC721(t *testing.T) {
+	SetABI(mockedABI)
+
+	owner := common.HexToHash("0x0000000000000000000000000000000000000000000000000000000000000123")
+	operator := common.HexToHash("0x0000000000000000000000000000000000000000000000000000000000000456")
+	tokenID := common.BigToHash(big.NewInt(7))
+
+	transferLog := types.Log{Topics: []common.Hash{TransferEventSigHash, owner, operator, tokenID}}
+	assert.Equal(t, StandardERC721, DetectStandard(transferLog))
+	event, err := UnpackLog(transferLog)
+	assert.Nil(t, err)
+	transfer, ok := event.(*ERC721Transfer)
+	assert.True(t, ok, "A Transfer with an indexed token ID should be unpacked as ERC-721")
+	assert.Equal(t, common.HexToAddress("0x0000000000000000000000000000000000000123"), transfer.From)
+	assert.Equal(t, common.HexToAddress("0x0000000000000000000000000000000000000456"), transfer.To)
+	assert.Equal(t, big.NewInt(7), transfer.TokenID)
+
+	event, err = UnpackLog(types.Log{Topics: []common.Hash{ApprovalEventSigHash, owner, operator, tokenID}})
+	assert.Nil(t, err)
+	approval, ok := event.(*ERC721Approval)
+	assert.True(t, ok)
+	assert.Equal(t, common.HexToAddress("0x0000000000000000000000000000000000000456"), approval.Approved)
+	assert.Equal(t, big.NewInt(7), approval.TokenID)
+
+	event, err = UnpackLog(types.Log{
+		Topics: []common.Hash{ApprovalForAllEventSigHash, owner, operator},
+		Data:   common.LeftPadBytes([]byte{1}, 32),
+	})
+	assert.Nil(t, err)
+	approvalForAll, ok := event.(*ApprovalForAll)
+	assert.True(t, ok)
+	assert.Equal(t, common.HexToAddress("0x0000000000000000000000000000000000000456"), approvalForAll.Operator)
+	assert.True(t, approvalForAll.Approved)
+
+	_, err = UnpackLog(types.Log{Topics: []common.Hash{TransferEventSigHash, owner, operator, tokenID}, Data: make([]byte, 32)})
+	assert.ErrorIs(t, err, ErrMalformedLog, "ERC-721 Transfers carry no data")
+
+	_, err = UnpackLog(types.Log{
+		Topics: []common.Hash{ApprovalForAllEventSigHash, owner, operator},
+		Data:   common.LeftPadBytes([]byte{2}, 32),
+	})
+	assert.ErrorIs(t, err, ErrMalformedLog, "Invalid booleans should be rejected")
+}
+
+func TestUnpackLog_ERC1155(t *testing.T) {
+	SetABI(mockedABI)
+
+	operator := common.HexToHash("0x0000000000000000000000000000000000000000000000000000000000000789")
+	from := common.HexToHash("0x0000000000000000000000000000000000000000000000000000000000000123")
+	to := common.HexToHash("0x0000000000000000000000000000000000000000000000000000000000000456")
+
+	event, err := UnpackLog(types.Log{
+		Topics: []common.Hash{TransferSingleEventSigHash, operator, from, to},
+		Data:   append(common.LeftPadBytes([]byte{7}, 32), common.LeftPadBytes([]byte{100}, 32)...),
+	})
+	assert.Nil(t, err)
+	single, ok := event.(*ERC1155TransferSingle)
+	assert.True(t, ok)
+	assert.Equal(t, common.HexToAddress("0x0000000000000000000000000000000000000789"), single.Operator)
+	assert.Equal(t, common.HexToAddress("0x0000000000000000000000000000000000000456"), single.To)
+	assert.Equal(t, big.NewInt(7), single.ID)
+	assert.Equal(t, big.NewInt(100), single.Value)
+
+	data, err := transferBatchData.Pack([]*big.Int{big.NewInt(1), big.NewInt(2)}, []*big.Int{big.NewInt(10), big.NewInt(20)})
+	assert.Nil(t, err)
+	event, err = UnpackLog(types.Log{Topics: []common.Hash{TransferBatchEventSigHash, operator, from, to}, Data: data})
+	assert.Nil(t, err)
+	batch, ok := event.(*ERC1155TransferBatch)
+	assert.True(t, ok)
+	assert.Equal(t, []*big.Int{big.NewInt(1), big.NewInt(2)}, batch.IDs)
+	assert.Equal(t, []*big.Int{big.NewInt(10), big.NewInt(20)}, batch.Values)
+
+	data, err = transferBatchData.Pack([]*big.Int{big.NewInt(1)}, []*big.Int{})
+	assert.Nil(t, err)
+	_, err = UnpackLog(types.Log{Topics: []common.Hash{TransferBatchEventSigHash, operator, from, to}, Data: data})
+	assert.ErrorIs(t, err, ErrMalformedLog, "Batches should have as many values as ids")
+
+	data, err = uriData.Pack("ipfs://token/7.json")
+	assert.Nil(t, err)
+	event, err = UnpackLog(types.Log{Topics: []common.Hash{URIEventSigHash, common.BigToHash(big.NewInt(7))}, Data: data})
+	assert.Nil(t, err)
+	uri, ok := event.(*ERC1155URI)
+	assert.True(t, ok)
+	assert.Equal(t, "ipfs://token/7.json", uri.Value)
+	assert.Equal(t, big.NewInt(7), uri.ID)
+}
+
+func TestDetectStandard(t *testing.T) {
+	topic := common.Hash{}
+	assert.Equal(t, StandardERC20, DetectStandard(types.Log{Topics: []common.Hash{TransferEventSigHash, topic, topic}}))
+	assert.Equal(t, StandardERC20, DetectStandard(types.Log{Topics: []common.Hash{ApprovalEventSigHash, topic, topic}}))
+	assert.Equal(t, StandardERC721, DetectStandard(types.Log{Topics: []common.Hash{TransferEventSigHash, topic, topic, topic}}))
+	assert.Equal(t, StandardERC1155, DetectStandard(types.Log{Topics: []common.Hash{TransferBatchEventSigHash, topic, topic, topic}}))
+	assert.Equal(t, "", DetectStandard(types.Log{Topics: []common.Hash{TransferEventSigHash}}))
+	assert.Equal(t, "", DetectStandard(types.Log{Topics: []common.Hash{ApprovalForAllEventSigHash, topic, topic}}))
+	assert.Equal(t, "", DetectStandard(types.Log{}))
+}
+
+func TestUnpackLog_Malformed(t *testing.T) {
+	SetABI(mockedABI)
+
+	_, err := UnpackLog(types.Log{})
+	assert.ErrorIs(t, err, ErrAnonymousLog, "Logs without topics should be rejected")
+
+	// A Transfer declared without indexed arguments carries all of them in the data
+	_, err = UnpackLog(types.Log{
+		Topics: []common.Hash{TransferEventSigHash},
+		Data:   make([]byte, 96),
+	})
+	var decodeErr *DecodeError
+	assert.ErrorAs(t, err, &decodeErr)
+	assert.Equal(t, "Transfer", decodeErr.Event)
+	assert.ErrorIs(t, err, ErrMalformedLog)
+
+	_, err = UnpackLog(types.Log{
+		Topics: []common.Hash{ApprovalEventSigHash, {}, {}},
+		Data:   make([]byte, 16),
+	})
+	assert.ErrorIs(t, err, ErrMalformedLog, "Truncated data should be rejected")
+}
+
+func FuzzUnpackLog(f *testing.F) {
+	SetABI(mockedABI)
+
+	f.Add(uint8(3), TransferEventSigHash.Bytes(), hexToBytes("00000000000000000000000000000000000000000000000000000000000003e8"))
+	f.Add(uint8(3), ApprovalEventSigHash.Bytes(), []byte{})
+	f.Add(uint8(1), TransferEventSigHash.Bytes(), make([]byte, 96))
+	f.Add(uint8(0), []byte{}, []byte{0x01})
+	f.Add(uint8(4), TransferEventSigHash.Bytes(), []byte{})
+	f.Add(uint8(3), ApprovalForAllEventSigHash.Bytes(), common.LeftPadBytes([]byte{1}, 32))
+	f.Add(uint8(4), TransferSingleEventSigHash.Bytes(), make([]byte, 64))
+	f.Add(uint8(4), TransferBatchEventSigHash.Bytes(), make([]byte, 128))
+	f.Add(uint8(2), URIEventSigHash.Bytes(), make([]byte, 64))
+
+	f.Fuzz(func(t *testing.T, topicCount uint8, topic0 []byte, data []byte) {
+		log := types.Log{Data: data}
+		for i := 0; i < int(topicCount%6); i++ {
+			topic := common.BytesToHash(topic0)
+			if i > 0 {
+				topic = common.BigToHash(big.NewInt(int64(i)))
+			}
+			log.Topics = append(log.Topics, topic)
+		}
+
+		event, err := UnpackLog(log)
+		if err != nil {
+			assert.Nil(t, event)
+			return
+		}
+		if len(log.Topics) == 0 {
+			t.Fatal("Logs without topics should not decode")
+		}
+	})
+}
+
 // Helper function to convert hex string to bytes
 func hexToBytes(hexString string) []byte {
 	bytes, err := hex.DecodeString(hexString)
@@ -0,0 +1,151 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// DecodedEvent is an event decoded generically from the loaded ABI.
+type DecodedEvent struct {
+	Name      string
+	Signature string
+	Args      []Argument
+}
+
+// Argument is a named event argument, in the order of the event declaration.
+type Argument struct {
+	Name    string
+	Type    string
+	Indexed bool
+	Value   interface{}
+}
+
+// DecodeLog decodes a log with the event of the loaded ABI matching its first topic.
+// Indexed arguments of dynamic types only carry the keccak256 hash of their value.
+func DecodeLog(log types.Log) (*DecodedEvent, error) {
+	if len(log.Topics) == 0 {
+		return nil, ErrAnonymousLog
+	}
+
+	event, err := ParsedABI.EventByID(log.Topics[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var indexed abi.Arguments
+	for _, input := range event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+
+	if err := checkTopics(event.Sig, len(log.Topics), len(indexed)+1); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	if err := abi.ParseTopicsIntoMap(values, indexed, log.Topics[1:]); err != nil {
+		return nil, &DecodeError{Event: event.Sig, Reason: fmt.Sprintf("invalid indexed arguments: %v", err)}
+	}
+	if err := event.Inputs.NonIndexed().UnpackIntoMap(values, log.Data); err != nil {
+		return nil, &DecodeError{Event: event.Sig, Reason: fmt.Sprintf("invalid data: %v", err)}
+	}
+
+	decoded := &DecodedEvent{Name: event.RawName, Signature: event.Sig}
+	for _, input := range event.Inputs {
+		decoded.Args = append(decoded.Args, Argument{
+			Name:    input.Name,
+			Type:    input.Type.String(),
+			Indexed: input.Indexed,
+			Value:   values[input.Name],
+		})
+	}
+	return decoded, nil
+}
+
+// Arg returns the value of the named argument, or nil if the event has no such argument.
+func (e *DecodedEvent) Arg(name string) interface{} {
+	for _, arg := range e.Args {
+		if arg.Name == name {
+			return arg.Value
+		}
+	}
+	return nil
+}
+
+// MarshalJSON encodes the argument with integers as decimal strings and addresses,
+// hashes and byte values as hex strings.
+func (a Argument) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name    string      `json:"name"`
+		Type    string      `json:"type"`
+		Indexed bool        `json:"indexed"`
+		Value   interface{} `json:"value"`
+	}{a.Name, a.Type, a.Indexed, jsonValue(reflect.ValueOf(a.Value))})
+}
+
+// jsonValue converts a decoded ABI value into a JSON friendly representation.
+func jsonValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	switch value := v.Interface().(type) {
+	case *big.Int:
+		if value == nil {
+			return nil
+		}
+		return value.String()
+	case common.Address:
+		return value.Hex()
+	case common.Hash:
+		return value.Hex()
+	case []byte:
+		return hexutil.Encode(value)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return jsonValue(v.Elem())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprint(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprint(v.Uint())
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			bytes := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(bytes), v)
+			return hexutil.Encode(bytes)
+		}
+		fallthrough
+	case reflect.Slice:
+		values := make([]interface{}, v.Len())
+		for i := range values {
+			values[i] = jsonValue(v.Index(i))
+		}
+		return values
+	case reflect.Struct:
+		fields := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "" {
+				tag = field.Name
+			}
+			fields[tag] = jsonValue(v.Field(i))
+		}
+		return fields
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+const mockedCustomABI = `[{"anonymous":false,"inputs":[{"indexed":true,"name":"user","type":"address"},{"indexed":true,"name":"memo","type":"string"},{"indexed":false,"name":"amount","type":"uint256"},{"indexed":false,"name":"flags","type":"bytes4"},{"indexed":false,"name":"ids","type":"uint64[]"}],"name":"Deposit","type":"event"},{"anonymous":false,"inputs":[{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":false,"name":"value","type":"uint256"}],"name":"Transfer","type":"event"}]`
+
+var depositEventSigHash = crypto.Keccak256Hash([]byte("Deposit(address,string,uint256,bytes4,uint64[])"))
+
+// depositLog creates a Deposit log with the given amount
+func depositLog() types.Log {
+	data := append(common.LeftPadBytes(big.NewInt(1000).Bytes(), 32), common.RightPadBytes([]byte{0xca, 0xfe, 0xba, 0xbe}, 32)...)
+	data = append(data, common.LeftPadBytes(big.NewInt(96).Bytes(), 32)...) // offset of ids
+	data = append(data, common.LeftPadBytes(big.NewInt(2).Bytes(), 32)...)  // length of ids
+	data = append(data, common.LeftPadBytes(big.NewInt(7).Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(big.NewInt(9).Bytes(), 32)...)
+
+	return types.Log{
+		Topics: []common.Hash{
+			depositEventSigHash,
+			common.HexToHash("0x0000000000000000000000000000000000000000000000000000000000000123"),
+			crypto.Keccak256Hash([]byte("hello")),
+		},
+		Data: data,
+	}
+}
+
+func TestDecodeLog(t *testing.T) {
+	SetABI(mockedCustomABI)
+	defer SetABI(mockedABI)
+
+	event, err := DecodeLog(depositLog())
+	assert.Nil(t, err)
+	assert.Equal(t, "Deposit", event.Name)
+	assert.Equal(t, "Deposit(address,string,uint256,bytes4,uint64[])", event.Signature)
+
+	// Arguments keep the order of the event declaration
+	var names []string
+	for _, arg := range event.Args {
+		names = append(names, arg.Name)
+	}
+	assert.Equal(t, []string{"user", "memo", "amount", "flags", "ids"}, names)
+
+	assert.Equal(t, common.HexToAddress("0x0000000000000000000000000000000000000123"), event.Arg("user"))
+	assert.Equal(t, crypto.Keccak256Hash([]byte("hello")), event.Arg("memo"), "Indexed strings should be decoded to their hash")
+	assert.Equal(t, big.NewInt(1000), event.Arg("amount"))
+	assert.True(t, event.Args[0].Indexed)
+	assert.False(t, event.Args[2].Indexed)
+}
+
+func TestDecodeLog_JSON(t *testing.T) {
+	SetABI(mockedCustomABI)
+	defer SetABI(mockedABI)
+
+	event, err := DecodeLog(depositLog())
+	assert.Nil(t, err)
+
+	args, err := json.Marshal(event.Args)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[
+		{"name":"user","type":"address","indexed":true,"value":"0x0000000000000000000000000000000000000123"},
+		{"name":"memo","type":"string","indexed":true,"value":"`+crypto.Keccak256Hash([]byte("hello")).Hex()+`"},
+		{"name":"amount","type":"uint256","indexed":false,"value":"1000"},
+		{"name":"flags","type":"bytes4","indexed":false,"value":"0xcafebabe"},
+		{"name":"ids","type":"uint64[]","indexed":false,"value":["7","9"]}
+	]`, string(args))
+}
+
+func TestUnpackLog_Generic(t *testing.T) {
+	SetABI(mockedCustomABI)
+	defer SetABI(mockedABI)
+
+	event, err := UnpackLog(depositLog())
+	assert.Nil(t, err)
+	decoded, ok := event.(*DecodedEvent)
+	assert.True(t, ok, "Events other than Transfer and Approval should be decoded generically")
+	assert.Equal(t, "Deposit", decoded.Name)
+
+	// Events missing from the ABI are still reported as unknown
+	unknown := types.Log{Topics: []common.Hash{crypto.Keccak256Hash([]byte("Unknown()"))}}
+	event, err = UnpackLog(unknown)
+	assert.Nil(t, err)
+	assert.Nil(t, event)
+}
@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrAnonymousLog is returned for logs without topics, which cannot be matched to an event.
+	ErrAnonymousLog = errors.New("log has no topics")
+	// ErrMalformedLog is matched by every DecodeError.
+	ErrMalformedLog = errors.New("malformed log")
+)
+
+// DecodeError is returned when the topics or data of a log do not match the event
+// identified by its first topic.
+type DecodeError struct {
+	Event  string
+	Reason string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("malformed %s log: %s", e.Event, e.Reason)
+}
+
+// Unwrap allows matching the error with errors.Is(err, ErrMalformedLog).
+func (e *DecodeError) Unwrap() error {
+	return ErrMalformedLog
+}
+
+// checkTopics returns a DecodeError if a log does not have the expected number of topics.
+func checkTopics(event string, topics, expected int) error {
+	if topics != expected {
+		return &DecodeError{Event: event, Reason: fmt.Sprintf("expected %d topics, got %d", expected, topics)}
+	}
+	return nil
+}
+
+// checkData returns a DecodeError if the data of a log does not have the expected length.
+func checkData(event string, data []byte, expected int) error {
+	if len(data) != expected {
+		return &DecodeError{Event: event, Reason: fmt.Sprintf("expected %d bytes of data, got %d", expected, len(data))}
+	}
+	return nil
+}
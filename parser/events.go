@@ -6,16 +6,36 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Token standards, as detected from the layout of a log.
+const (
+	StandardERC20   = "ERC-20"
+	StandardERC721  = "ERC-721"
+	StandardERC1155 = "ERC-1155"
 )
 
 var (
-	transferEventSignature = []byte("Transfer(address,address,uint256)")
-	approvalEventSignature = []byte("Approval(address,address,uint256)")
+	transferEventSignature       = []byte("Transfer(address,address,uint256)")
+	approvalEventSignature       = []byte("Approval(address,address,uint256)")
+	approvalForAllEventSignature = []byte("ApprovalForAll(address,address,bool)")
+	transferSingleEventSignature = []byte("TransferSingle(address,address,address,uint256,uint256)")
+	transferBatchEventSignature  = []byte("TransferBatch(address,address,address,uint256[],uint256[])")
+	uriEventSignature            = []byte("URI(string,uint256)")
 
 	// TransferEventSigHash is the signature hash for the Transfer event.
 	TransferEventSigHash = crypto.Keccak256Hash(transferEventSignature)
 	// ApprovalEventSigHash is the signature hash for the Approval event.
 	ApprovalEventSigHash = crypto.Keccak256Hash(approvalEventSignature)
+	// ApprovalForAllEventSigHash is the signature hash for the ApprovalForAll event.
+	ApprovalForAllEventSigHash = crypto.Keccak256Hash(approvalForAllEventSignature)
+	// TransferSingleEventSigHash is the signature hash for the ERC-1155 TransferSingle event.
+	TransferSingleEventSigHash = crypto.Keccak256Hash(transferSingleEventSignature)
+	// TransferBatchEventSigHash is the signature hash for the ERC-1155 TransferBatch event.
+	TransferBatchEventSigHash = crypto.Keccak256Hash(transferBatchEventSignature)
+	// URIEventSigHash is the signature hash for the ERC-1155 URI event.
+	URIEventSigHash = crypto.Keccak256Hash(uriEventSignature)
 )
 
 // ERC20Transfer represents the Transfer event.
@@ -31,3 +51,75 @@ type ERC20Approval struct {
 	Spender common.Address
 	Value   *big.Int
 }
+
+// ERC721Transfer represents the Transfer event of a non-fungible token.
+type ERC721Transfer struct {
+	From    common.Address
+	To      common.Address
+	TokenID *big.Int
+}
+
+// ERC721Approval represents the Approval event of a non-fungible token.
+type ERC721Approval struct {
+	Owner    common.Address
+	Approved common.Address
+	TokenID  *big.Int
+}
+
+// ApprovalForAll represents the ApprovalForAll event, which enables or disables an
+// operator for all the tokens of an owner.
+type ApprovalForAll struct {
+	Owner    common.Address
+	Operator common.Address
+	Approved bool
+}
+
+// ERC1155TransferSingle represents the TransferSingle event of a multi-token.
+type ERC1155TransferSingle struct {
+	Operator common.Address
+	From     common.Address
+	To       common.Address
+	ID       *big.Int
+	Value    *big.Int
+}
+
+// ERC1155TransferBatch represents the TransferBatch event of a multi-token. Values[i]
+// is the amount transferred of IDs[i].
+type ERC1155TransferBatch struct {
+	Operator common.Address
+	From     common.Address
+	To       common.Address
+	IDs      []*big.Int
+	Values   []*big.Int
+}
+
+// ERC1155URI represents the URI event, emitted when the metadata URI of a token changes.
+type ERC1155URI struct {
+	Value string
+	ID    *big.Int
+}
+
+// DetectStandard returns the token standard of a token event log, or an empty string for
+// other logs. ERC-20 and ERC-721 share the signatures of Transfer and Approval, but
+// ERC-721 indexes the token ID as a fourth topic where ERC-20 carries the value in the
+// data. ApprovalForAll is shared by ERC-721 and ERC-1155 and cannot be told apart.
+func DetectStandard(log types.Log) string {
+	if len(log.Topics) == 0 {
+		return ""
+	}
+	switch log.Topics[0] {
+	case TransferSingleEventSigHash, TransferBatchEventSigHash, URIEventSigHash:
+		return StandardERC1155
+	case TransferEventSigHash, ApprovalEventSigHash:
+	default:
+		return ""
+	}
+	switch len(log.Topics) {
+	case 3:
+		return StandardERC20
+	case 4:
+		return StandardERC721
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+
+	"go-contract-indexer/db"
+	"go-contract-indexer/loghandler"
+	"go-contract-indexer/parser"
+)
+
+// runReplay handles the logs of a JSON fixture file with an in-memory database and
+// reports what was indexed, without connecting to a node or a database.
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	file := flags.String("file", "", "JSON file of logs as returned by eth_getLogs")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+
+	fixture, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer fixture.Close()
+
+	parser.Init()
+	store := db.NewMemoryDB()
+	n, err := loghandler.NewLogHandler(store, logger).Replay(context.Background(), fixture)
+	if err != nil {
+		return err
+	}
+
+	checkpoints := store.Checkpoints()
+	contracts := make([]string, 0, len(checkpoints))
+	for contract := range checkpoints {
+		contracts = append(contracts, contract)
+	}
+	sort.Strings(contracts)
+	for _, contract := range contracts {
+		logger.WithFields(logrus.Fields{
+			"contract":   contract,
+			"checkpoint": checkpoints[contract],
+			"holders":    len(store.Balances(contract)),
+		}).Info("Replayed contract")
+	}
+	logger.WithFields(logrus.Fields{
+		"logs":            n,
+		"events":          len(store.Events()),
+		"nft_events":      len(store.NFTEvents()),
+		"contract_events": len(store.ContractEvents()),
+		"quarantined":     len(store.QuarantinedLogs()),
+	}).Info("Replay finished")
+	return nil
+}
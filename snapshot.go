@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"go-contract-indexer/db"
+)
+
+// runSnapshot writes a consistent copy of the bbolt database at DB_CONN_STR to a file,
+// which can be backed up or used as the database of another indexer.
+func runSnapshot(args []string) error {
+	flags := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	out := flags.String("out", "", "file to write the snapshot to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("-out is required")
+	}
+	path, ok := strings.CutPrefix(viper.GetString("DB_CONN_STR"), "bolt://")
+	if !ok {
+		return errors.New("snapshots require a bolt:// DB_CONN_STR")
+	}
+
+	store, err := db.OpenBolt(path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	n, err := store.Snapshot(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"file":  *out,
+		"bytes": n,
+	}).Info("Snapshot written")
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"go-contract-indexer/db"
+	"go-contract-indexer/erc20"
+	"go-contract-indexer/verify"
+)
+
+// runVerify reconciles the balances replayed from the indexed Transfer events of each
+// contract against balanceOf on chain, returning an error if any balance differs.
+func runVerify(args []string) error {
+	sampleSize := verify.DefaultSampleSize
+	if viper.IsSet("VERIFY_SAMPLE_SIZE") {
+		sampleSize = viper.GetInt("VERIFY_SAMPLE_SIZE")
+	}
+
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	contract := flags.String("contract", "", "only verify this contract")
+	block := flags.Uint64("block", 0, "block to compare balances at (default: the contract's sync checkpoint)")
+	sample := flags.Int("sample", sampleSize, "number of holders to check, 0 for all")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	contracts, err := loadContracts()
+	if err != nil {
+		return err
+	}
+	endpoints, err := loadEndpoints()
+	if err != nil {
+		return err
+	}
+	client, err := dialEndpoints(endpoints)
+	if err != nil {
+		return err
+	}
+
+	database := db.InitDB(viper.GetString("DB_CONN_STR"))
+	defer database.Close()
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	mismatches := 0
+	for _, c := range contracts {
+		address := common.HexToAddress(c.Address)
+		if *contract != "" && !strings.EqualFold(*contract, address.Hex()) {
+			continue
+		}
+
+		pinned := *block
+		if pinned == 0 {
+			checkpoint, ok, err := database.GetCheckpoint(address.Hex())
+			if err != nil {
+				return err
+			}
+			if !ok {
+				logger.Infof("Skipping %s, nothing has been indexed yet", address.Hex())
+				continue
+			}
+			pinned = checkpoint
+		}
+
+		balances, err := database.ReplayBalances(address.Hex(), pinned)
+		if err != nil {
+			return fmt.Errorf("failed to replay the transfers of %s: %v", address.Hex(), err)
+		}
+		caller, err := erc20.NewErc20Caller(address, client)
+		if err != nil {
+			return fmt.Errorf("failed to instantiate token contract: %v", err)
+		}
+		report, err := verify.Reconcile(context.Background(), caller, balances, pinned, *sample, rng)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range report.Mismatches {
+			logger.WithFields(logrus.Fields{
+				"contract": address.Hex(),
+				"holder":   m.Holder,
+				"indexed":  m.Indexed.String(),
+				"onchain":  m.OnChain.String(),
+			}).Warn("Balance mismatch")
+		}
+		logger.WithFields(logrus.Fields{
+			"contract":   address.Hex(),
+			"label":      c.Label,
+			"block":      report.Block,
+			"holders":    report.Holders,
+			"checked":    report.Checked,
+			"mismatches": len(report.Mismatches),
+		}).Info("Verified balances")
+		mismatches += len(report.Mismatches)
+	}
+
+	if mismatches > 0 {
+		return fmt.Errorf("%d balances differ from the chain", mismatches)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+)
+
+// SupplyCaller reads the total supply of a token from the chain, as erc20.Erc20Caller does.
+type SupplyCaller interface {
+	TotalSupply(opts *bind.CallOpts) (*big.Int, error)
+}
+
+// CheckSupply compares the total supply tracked from mints and burns with totalSupply at
+// the block it was last changed in. It returns the on-chain supply and whether both agree.
+func CheckSupply(ctx context.Context, caller SupplyCaller, indexed *big.Int, block uint64) (*big.Int, bool, error) {
+	onChain, err := caller.TotalSupply(&bind.CallOpts{Context: ctx, BlockNumber: new(big.Int).SetUint64(block)})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get the total supply at block %d: %v", block, err)
+	}
+	return onChain, onChain.Cmp(indexed) == 0, nil
+}
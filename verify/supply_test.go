@@ -0,0 +1,41 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSupplyCaller serves a fixed total supply
+type fakeSupplyCaller struct {
+	supply *big.Int
+	block  uint64
+	err    error
+}
+
+func (c *fakeSupplyCaller) TotalSupply(opts *bind.CallOpts) (*big.Int, error) {
+	c.block = opts.BlockNumber.Uint64()
+	return c.supply, c.err
+}
+
+func TestCheckSupply(t *testing.T) {
+	caller := &fakeSupplyCaller{supply: big.NewInt(150)}
+
+	onChain, ok, err := CheckSupply(context.Background(), caller, big.NewInt(150), 12)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, big.NewInt(150), onChain)
+	assert.Equal(t, uint64(12), caller.block, "The supply should be read at the block it was tracked at")
+
+	_, ok, err = CheckSupply(context.Background(), caller, big.NewInt(149), 12)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	caller.err = errors.New("execution reverted")
+	_, _, err = CheckSupply(context.Background(), caller, big.NewInt(150), 12)
+	assert.ErrorContains(t, err, "execution reverted")
+}
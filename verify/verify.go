@@ -0,0 +1,66 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// DefaultSampleSize is how many holders are checked when no sample size is configured.
+const DefaultSampleSize = 100
+
+// BalanceCaller reads token balances from the chain, as erc20.Erc20Caller does.
+type BalanceCaller interface {
+	BalanceOf(opts *bind.CallOpts, account common.Address) (*big.Int, error)
+}
+
+// Mismatch is a holder whose replayed balance differs from its on-chain balance.
+type Mismatch struct {
+	Holder  string
+	Indexed *big.Int
+	OnChain *big.Int
+}
+
+// Report is the outcome of reconciling the replayed balances of a token.
+type Report struct {
+	Block      uint64
+	Holders    int
+	Checked    int
+	Mismatches []Mismatch
+}
+
+// Reconcile compares a sample of replayed balances against balanceOf at the given block.
+// Up to sampleSize holders are picked at random with rng, or all of them when sampleSize
+// is not positive. Balances replayed up to a block are only comparable to on-chain
+// balances at the same block, so the node must serve state at that block.
+func Reconcile(ctx context.Context, caller BalanceCaller, balances map[string]*big.Int, block uint64, sampleSize int, rng *rand.Rand) (*Report, error) {
+	holders := make([]string, 0, len(balances))
+	for holder := range balances {
+		holders = append(holders, holder)
+	}
+	sort.Strings(holders)
+	if sampleSize > 0 && sampleSize < len(holders) {
+		rng.Shuffle(len(holders), func(i, j int) { holders[i], holders[j] = holders[j], holders[i] })
+		holders = holders[:sampleSize]
+		sort.Strings(holders)
+	}
+
+	report := &Report{Block: block, Holders: len(balances)}
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: new(big.Int).SetUint64(block)}
+	for _, holder := range holders {
+		onChain, err := caller.BalanceOf(opts, common.HexToAddress(holder))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the balance of %s at block %d: %v", holder, block, err)
+		}
+		report.Checked++
+		if onChain.Cmp(balances[holder]) != 0 {
+			report.Mismatches = append(report.Mismatches, Mismatch{Holder: holder, Indexed: balances[holder], OnChain: onChain})
+		}
+	}
+	return report, nil
+}
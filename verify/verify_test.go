@@ -0,0 +1,70 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCaller serves balances and records the block of each call
+type fakeCaller struct {
+	balances map[common.Address]*big.Int
+	blocks   []uint64
+	err      error
+}
+
+func (c *fakeCaller) BalanceOf(opts *bind.CallOpts, account common.Address) (*big.Int, error) {
+	c.blocks = append(c.blocks, opts.BlockNumber.Uint64())
+	if c.err != nil {
+		return nil, c.err
+	}
+	if balance, ok := c.balances[account]; ok {
+		return balance, nil
+	}
+	return new(big.Int), nil
+}
+
+const (
+	alice = "0x1234567890AbcdEF1234567890aBcdef12345678"
+	bob   = "0x1234567890abCDeF1234567890ABCdEf12345679"
+)
+
+func TestReconcile(t *testing.T) {
+	caller := &fakeCaller{balances: map[common.Address]*big.Int{
+		common.HexToAddress(alice): big.NewInt(70),
+		common.HexToAddress(bob):   big.NewInt(29), // a fee was taken on transfer
+	}}
+	balances := map[string]*big.Int{alice: big.NewInt(70), bob: big.NewInt(30)}
+
+	report, err := Reconcile(context.Background(), caller, balances, 1234, 0, rand.New(rand.NewSource(1)))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Checked)
+	assert.Equal(t, []uint64{1234, 1234}, caller.blocks, "Balances should be read at the pinned block")
+	assert.Equal(t, []Mismatch{{Holder: bob, Indexed: big.NewInt(30), OnChain: big.NewInt(29)}}, report.Mismatches)
+}
+
+func TestReconcile_Sample(t *testing.T) {
+	caller := &fakeCaller{}
+	balances := make(map[string]*big.Int)
+	for i := int64(1); i <= 50; i++ {
+		balances[common.BigToAddress(big.NewInt(i)).Hex()] = new(big.Int)
+	}
+
+	report, err := Reconcile(context.Background(), caller, balances, 1, 10, rand.New(rand.NewSource(1)))
+	assert.NoError(t, err)
+	assert.Equal(t, 50, report.Holders)
+	assert.Equal(t, 10, report.Checked, "Only the sampled holders should be checked")
+	assert.Empty(t, report.Mismatches)
+}
+
+func TestReconcile_Error(t *testing.T) {
+	caller := &fakeCaller{err: errors.New("missing trie node")}
+	_, err := Reconcile(context.Background(), caller, map[string]*big.Int{alice: big.NewInt(1)}, 1, 0, rand.New(rand.NewSource(1)))
+	assert.ErrorContains(t, err, "missing trie node")
+}